@@ -14,6 +14,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -124,6 +125,8 @@ func (c *Client) CreateWithOptions(ctx context.Context, content []byte, opts Cre
 		return "", &Error{Code: ErrPayloadTooLarge, Message: strings.TrimSpace(string(body))}
 	case http.StatusForbidden:
 		return "", &Error{Code: ErrBlacklisted, Message: strings.TrimSpace(string(body))}
+	case http.StatusUnprocessableEntity:
+		return "", &Error{Code: ErrSecrets, Message: strings.TrimSpace(string(body))}
 	case http.StatusBadRequest:
 		return "", &Error{Code: ErrBadRequest, Message: strings.TrimSpace(string(body))}
 	default:
@@ -131,9 +134,50 @@ func (c *Client) CreateWithOptions(ctx context.Context, content []byte, opts Cre
 	}
 }
 
+// GetOptions selects part of a paste by line. The server applies each option
+// to the lines left by the previous ones, in the order listed.
+type GetOptions struct {
+	// FromLine and ToLine are the first and last lines to return, counting
+	// from 1. ToLine zero means the end of the paste.
+	FromLine, ToLine int
+	// Grep returns only lines matching this regular expression (Go syntax).
+	Grep string
+	// Head returns only the first Head lines.
+	Head int
+	// Tail returns only the last Tail lines.
+	Tail int
+}
+
+func (o GetOptions) query() string {
+	q := url.Values{}
+	switch {
+	case o.FromLine > 0 && o.ToLine > 0:
+		q.Set("lines", fmt.Sprintf("%d-%d", o.FromLine, o.ToLine))
+	case o.FromLine > 0:
+		q.Set("lines", fmt.Sprintf("%d-", o.FromLine))
+	case o.ToLine > 0:
+		q.Set("lines", fmt.Sprintf("1-%d", o.ToLine))
+	}
+	if o.Grep != "" {
+		q.Set("grep", o.Grep)
+	}
+	if o.Head > 0 {
+		q.Set("head", strconv.Itoa(o.Head))
+	}
+	if o.Tail > 0 {
+		q.Set("tail", strconv.Itoa(o.Tail))
+	}
+	return q.Encode()
+}
+
 // Get retrieves a paste by its identifier.
 // The identifier can be either a full URL (https://ig.lc/abc123) or just the ID (abc123).
 func (c *Client) Get(ctx context.Context, identifier string) ([]byte, error) {
+	return c.GetWithOptions(ctx, identifier, GetOptions{})
+}
+
+// GetWithOptions retrieves the lines of a paste selected by opts.
+func (c *Client) GetWithOptions(ctx context.Context, identifier string, opts GetOptions) ([]byte, error) {
 	// Handle full URLs by extracting the identifier
 	id := identifier
 	if strings.HasPrefix(identifier, "http://") || strings.HasPrefix(identifier, "https://") {
@@ -149,6 +193,9 @@ func (c *Client) Get(ctx context.Context, identifier string) ([]byte, error) {
 	}
 
 	endpoint := c.baseURL + "/" + id
+	if q := opts.query(); q != "" {
+		endpoint += "?" + q
+	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 	if err != nil {
@@ -173,6 +220,8 @@ func (c *Client) Get(ctx context.Context, identifier string) ([]byte, error) {
 		return nil, &Error{Code: ErrNotFound, Message: "paste not found or expired"}
 	case http.StatusTooManyRequests:
 		return nil, &Error{Code: ErrRateLimited, Message: strings.TrimSpace(string(body))}
+	case http.StatusBadRequest:
+		return nil, &Error{Code: ErrBadRequest, Message: strings.TrimSpace(string(body))}
 	default:
 		return nil, &Error{Code: ErrServer, Message: fmt.Sprintf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))}
 	}
@@ -40,6 +40,24 @@
 //
 //	url, err := c.CreateWithOptions(ctx, content, client.CreateOptions{Secure: true})
 //
+// # Part of a Paste
+//
+// Fetch only some lines of a long paste, such as the errors near the end:
+//
+//	content, err := c.GetWithOptions(ctx, url, client.GetOptions{Grep: "ERROR", Tail: 20})
+//
+// # Large Files
+//
+// Upload sends content in chunks that survive dropped connections, for
+// pastes larger than a single request allows:
+//
+//	f, err := os.Open("build.log")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer f.Close()
+//	url, err := c.Upload(ctx, f, client.UploadOptions{})
+//
 // # Custom Configuration
 //
 //	c := client.New(
@@ -22,6 +22,8 @@ const (
 	ErrBadRequest
 	// ErrServer is returned for server-side errors.
 	ErrServer
+	// ErrSecrets is returned when the server rejects content that appears to contain credentials.
+	ErrSecrets
 )
 
 // Error represents an error from the Pastey API.
@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultChunkSize is how much of the content Upload sends per request.
+	DefaultChunkSize = 4 << 20
+
+	// DefaultUploadRetries is how many times in a row Upload retries a failed
+	// request before giving up.
+	DefaultUploadRetries = 5
+
+	tusVersion = "1.0.0"
+)
+
+// UploadOptions configures a resumable upload.
+type UploadOptions struct {
+	// Secure generates a longer (32 char) ID instead of the default 7 char ID.
+	Secure bool
+	// ChunkSize is how many bytes are sent per request. Zero uses DefaultChunkSize.
+	ChunkSize int64
+	// Retries is how many times in a row a failed request is retried, with
+	// backoff, before Upload gives up. Zero uses DefaultUploadRetries.
+	Retries int
+	// UploadURL resumes an earlier upload of the same content instead of
+	// starting a new one.
+	UploadURL string
+	// OnCreate, if set, is called with the URL of a new upload before any
+	// content is sent. Save it to resume with UploadURL if Upload fails.
+	OnCreate func(uploadURL string)
+}
+
+// Upload sends content with the server's resumable upload protocol and
+// returns the paste URL. Content is sent in chunks, and after a failure
+// Upload asks the server how much arrived and continues from there, so it
+// suits large pastes (beyond MaxPayloadSize, up to the server's upload limit)
+// and unreliable connections.
+func (c *Client) Upload(ctx context.Context, content io.ReadSeeker, opts UploadOptions) (string, error) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return "", fmt.Errorf("finding content size: %w", err)
+	}
+	if size == 0 {
+		return "", &Error{Code: ErrEmptyContent, Message: "content cannot be empty"}
+	}
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	retries := opts.Retries
+	if retries <= 0 {
+		retries = DefaultUploadRetries
+	}
+
+	uploadURL := opts.UploadURL
+	if uploadURL == "" {
+		if uploadURL, err = c.createUpload(ctx, size, opts.Secure); err != nil {
+			return "", err
+		}
+		if opts.OnCreate != nil {
+			opts.OnCreate(uploadURL)
+		}
+	}
+
+	var offset int64
+	var pasteURL string
+	// A resumed upload starts by asking where to continue from
+	resync := opts.UploadURL != ""
+	for failures := 0; ; {
+		if resync {
+			offset, pasteURL, err = c.uploadOffset(ctx, uploadURL)
+		} else {
+			offset, pasteURL, err = c.patchUpload(ctx, uploadURL, content, offset, min(chunkSize, size-offset))
+		}
+		if err == nil && pasteURL != "" {
+			return pasteURL, nil
+		}
+		if err == nil && offset >= size && !resync {
+			// The upload completed earlier but the response was lost
+			resync = true
+			continue
+		}
+		if err == nil {
+			failures, resync = 0, false
+			continue
+		}
+
+		var apiErr *Error
+		if (errors.As(err, &apiErr) && apiErr.Code != ErrServer) || failures >= retries {
+			return "", err
+		}
+		if err := sleep(ctx, time.Second<<failures); err != nil {
+			return "", err
+		}
+		failures++
+		resync = true
+	}
+}
+
+// createUpload starts an upload of size bytes and returns its URL.
+func (c *Client) createUpload(ctx context.Context, size int64, secure bool) (string, error) {
+	endpoint := c.baseURL + "/uploads"
+	if secure {
+		endpoint += "?secure=true"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", uploadError(resp)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("reading upload location: %w", err)
+	}
+	return location.String(), nil
+}
+
+// uploadOffset asks the server how much of an upload it has, and for the
+// paste URL if it is complete.
+func (c *Client) uploadOffset(ctx context.Context, uploadURL string) (int64, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, uploadURL, nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Tus-Resumable", tusVersion)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", uploadError(resp)
+	}
+	return readOffset(resp)
+}
+
+// patchUpload sends n bytes of content from offset and returns the new
+// offset, and the paste URL if the upload is complete. If the server has a
+// different offset it is returned instead, to continue from.
+func (c *Client) patchUpload(ctx context.Context, uploadURL string, content io.ReadSeeker, offset, n int64) (int64, string, error) {
+	if _, err := content.Seek(offset, io.SeekStart); err != nil {
+		return 0, "", fmt.Errorf("seeking content: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, uploadURL, io.LimitReader(content, n))
+	if err != nil {
+		return 0, "", fmt.Errorf("creating request: %w", err)
+	}
+	req.ContentLength = n
+	req.Header.Set("Tus-Resumable", tusVersion)
+	req.Header.Set("Content-Type", "application/offset+octet-stream")
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, "", fmt.Errorf("making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusConflict:
+		return readOffset(resp)
+	default:
+		return 0, "", uploadError(resp)
+	}
+}
+
+func readOffset(resp *http.Response) (int64, string, error) {
+	offset, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return 0, "", &Error{Code: ErrServer, Message: "invalid Upload-Offset in response"}
+	}
+	return offset, resp.Header.Get("X-Pastey-URL"), nil
+}
+
+// uploadError converts an unsuccessful upload response to an *Error.
+func uploadError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	message := strings.TrimSpace(string(body))
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return &Error{Code: ErrNotFound, Message: "upload not found or expired"}
+	case http.StatusTooManyRequests:
+		return &Error{Code: ErrRateLimited, Message: message}
+	case http.StatusRequestEntityTooLarge:
+		return &Error{Code: ErrPayloadTooLarge, Message: message}
+	case http.StatusForbidden:
+		return &Error{Code: ErrBlacklisted, Message: message}
+	case http.StatusUnprocessableEntity:
+		return &Error{Code: ErrSecrets, Message: message}
+	case http.StatusBadRequest, http.StatusUnsupportedMediaType, http.StatusPreconditionFailed:
+		return &Error{Code: ErrBadRequest, Message: message}
+	default:
+		return &Error{Code: ErrServer, Message: fmt.Sprintf("unexpected status %d: %s", resp.StatusCode, message)}
+	}
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -1,56 +1,304 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
-	rate "github.com/wallstreetcn/rate/redis"
-
+	"github.com/tombowditch/pastey-serv/internal/acl"
+	"github.com/tombowditch/pastey-serv/internal/certs"
+	"github.com/tombowditch/pastey-serv/internal/clientip"
 	"github.com/tombowditch/pastey-serv/internal/config"
+	"github.com/tombowditch/pastey-serv/internal/filter"
+	"github.com/tombowditch/pastey-serv/internal/health"
+	"github.com/tombowditch/pastey-serv/internal/logging"
+	"github.com/tombowditch/pastey-serv/internal/paste"
+	"github.com/tombowditch/pastey-serv/internal/ratelimit"
+	"github.com/tombowditch/pastey-serv/internal/secrets"
+	"github.com/tombowditch/pastey-serv/internal/server/adminserver"
 	"github.com/tombowditch/pastey-serv/internal/server/httpserver"
+	"github.com/tombowditch/pastey-serv/internal/server/sshserver"
 	"github.com/tombowditch/pastey-serv/internal/server/tcpserver"
 	"github.com/tombowditch/pastey-serv/internal/store"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
 )
 
 func main() {
-	redisURI := os.Getenv("REDIS_URI")
+	if err := logging.Setup(os.Stderr, config.LogFormat(), config.LogLevel()); err != nil {
+		slog.Error("could not set up logging", "error", err)
+		os.Exit(1)
+	}
 
-	// Initialize store (Redis client with ping check)
-	s, err := store.NewRedis(redisURI, config.RedisPassword, config.RedisDB, config.PasteTTL)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, config.TracingExporter())
+	if err != nil {
+		slog.Error("could not set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	// Single Redis client shared by the store and rate limiters
+	rc, err := store.NewRedisClient(store.RedisConfig{
+		URI:            config.RedisURI(),
+		SentinelMaster: config.RedisSentinelMaster(),
+		SentinelAddrs:  config.RedisSentinelAddrs(),
+		ClusterAddrs:   config.RedisClusterAddrs(),
+		TLSCAFile:      config.RedisTLSCA(),
+	})
+	if err != nil {
+		slog.Error("invalid redis configuration", "error", err)
+		os.Exit(1)
+	}
+	defer rc.Close()
+
+	// Initialize store (verifies connectivity with a ping)
+	s, err := store.NewRedis(rc, config.PasteTTL, config.StoreTimeout())
 	if err != nil {
 		slog.Error("could not connect to redis", "error", err)
 		os.Exit(1)
 	}
 	slog.Info("connected to redis")
 
-	// Initialize rate limiter with parsed host/port
-	// Note: This creates a separate Redis connection (rate limiter library limitation)
-	redisHost, redisPort := store.ParseRedisURI(redisURI)
-	if err := rate.SetRedis(&rate.ConfigRedis{
-		Host: redisHost,
-		Port: redisPort,
-		Auth: config.RedisPassword,
-	}); err != nil {
-		slog.Error("could not initialize rate limiter", "error", err)
+	// Content filter, hot-reloaded from a file or Redis
+	contentFilter, err := filter.New(filter.DefaultRules)
+	if err != nil {
+		slog.Error("invalid default filter rules", "error", err)
+		os.Exit(1)
+	}
+	var filterSource filter.Source = filter.RedisSource{Client: rc, Key: "pastey_filter_rules"}
+	if path := config.FilterRulesFile(); path != "" {
+		filterSource = filter.FileSource{Path: path}
+	}
+	filterRules, err := contentFilter.Reload(ctx, filterSource, nil)
+	if err != nil && !errors.Is(err, filter.ErrNoRules) {
+		slog.Error("could not load filter rules", "source", filterSource.String(), "error", err)
+		os.Exit(1)
+	}
+	go contentFilter.Watch(ctx, filterSource, config.FilterReloadInterval(), filterRules)
+
+	// IP access control lists, hot-reloaded from a file
+	accessList := &acl.List{}
+	if path := config.ACLFile(); path != "" {
+		aclRules, err := accessList.Reload(ctx, path, nil)
+		if err != nil {
+			slog.Error("could not load access control lists", "path", path, "error", err)
+			os.Exit(1)
+		}
+		go accessList.Watch(ctx, path, config.ACLReloadInterval(), aclRules)
+	}
+
+	// Paste service shared by every transport
+	createLimiter := ratelimit.NewRedis(rc, "create", config.CreateRateInterval, config.CreateRateBurst)
+	readLimiter := ratelimit.NewRedis(rc, "read", config.ReadRateInterval, config.ReadRateBurst)
+	secretPolicy := paste.SecretPolicy(config.SecretPolicy())
+	switch secretPolicy {
+	case paste.SecretsOff, paste.SecretsReject, paste.SecretsRedact, paste.SecretsRestrict:
+	default:
+		slog.Error("invalid secret policy", "policy", secretPolicy)
+		os.Exit(1)
+	}
+	pastes := paste.NewService(s, createLimiter, readLimiter, contentFilter, paste.Options{
+		ACL:             accessList,
+		Secrets:         secrets.NewScanner(secrets.DefaultDetectors()...),
+		SecretPolicy:    secretPolicy,
+		SecretTTL:       config.SecretTTL(),
+		LiveMaxDuration: config.LiveMaxDuration(),
+		UploadMaxSize:   config.UploadMaxSize(),
+	})
+
+	// Reverse proxies and load balancers allowed to report client addresses
+	proxies, err := clientip.NewResolver(config.TrustedProxies())
+	if err != nil {
+		slog.Error("invalid trusted proxies", "error", err)
+		os.Exit(1)
+	}
+
+	tcpSrv := tcpserver.New(pastes, tcpserver.Options{
+		ProxyProtocol:    config.TCPProxyProtocol(),
+		Proxies:          proxies,
+		MaxConns:         config.TCPMaxConns(),
+		MaxConnsPerIP:    config.TCPMaxConnsPerIP(),
+		MaxReadTime:      config.TCPMaxReadTime(),
+		MinReadRate:      config.TCPMinReadRate(),
+		FirstByteTimeout: config.TCPFirstByteTimeout(),
+		IdleTimeout:      config.TCPIdleTimeout(),
+		EOFIdleTimeout:   config.TCPEOFIdleTimeout(),
+		EOFMaxReadTime:   config.TCPEOFMaxReadTime(),
+		EOFMinReadRate:   config.TCPEOFMinReadRate(),
+	})
+
+	// Optional SSH interface
+	var sshSrv *sshserver.Server
+	if config.SSHAddr() != "" {
+		hostKey, err := sshserver.LoadHostKey(config.SSHHostKeyFile())
+		if err != nil {
+			slog.Error("could not load ssh host key", "error", err)
+			os.Exit(1)
+		}
+		var authorizedKeys map[string]string
+		if path := config.SSHAuthorizedKeysFile(); path != "" {
+			if authorizedKeys, err = sshserver.LoadAuthorizedKeys(path); err != nil {
+				slog.Error("could not load ssh authorized keys", "error", err)
+				os.Exit(1)
+			}
+		}
+		sshSrv = sshserver.New(pastes, sshserver.Options{
+			HostKey:        hostKey,
+			AuthorizedKeys: authorizedKeys,
+			MaxReadTime:    config.TCPMaxReadTime(),
+			MaxConns:       config.TCPMaxConns(),
+			MaxConnsPerIP:  config.TCPMaxConnsPerIP(),
+		})
+	}
+
+	// Readiness checks
+	checker := health.New()
+	checker.Add("store", s.Ping)
+	checker.Add("ratelimit", readLimiter.Ping)
+	checker.Add("tcp", tcpSrv.Check)
+	if sshSrv != nil {
+		checker.Add("ssh", sshSrv.Check)
+	}
+
+	// Admin API audit trail
+	audit, closeAudit, err := logging.OpenAudit(config.AdminAuditLog())
+	if err != nil {
+		slog.Error("could not open audit log", "error", err)
 		os.Exit(1)
 	}
+	defer closeAudit()
 
-	// Start TCP server
-	tcpAddr := config.TCPHost + ":" + config.TCPPort
-	tcpSrv := tcpserver.New(s)
+	adminTokens := config.AdminTokens()
+	if len(adminTokens) == 0 {
+		slog.Warn("ADMIN_TOKENS not set, moderation API disabled")
+	}
+
+	// Certificates for the TLS listeners, from files or ACME
+	var certManager *certs.Manager
+	if config.TLSEnabled() {
+		certManager, err = certs.New(certs.Config{
+			CertFile:         config.TLSCertFile(),
+			KeyFile:          config.TLSKeyFile(),
+			ACMEDomains:      config.ACMEDomains(),
+			ACMEDirectoryURL: config.ACMEDirectoryURL(),
+			ACMECAFile:       config.ACMECAFile(),
+			ACMECacheDir:     config.ACMECacheDir(),
+			ACMEEmail:        config.ACMEEmail(),
+		})
+		if err != nil {
+			slog.Error("invalid tls configuration", "error", err)
+			os.Exit(1)
+		}
+		go certManager.Watch(ctx, config.TLSReloadInterval())
+	}
+
+	serverOpts := httpserver.ServerOptions{
+		ReadHeaderTimeout: config.HTTPReadHeaderTimeout(),
+		ReadTimeout:       config.HTTPReadTimeout(),
+		WriteTimeout:      config.HTTPWriteTimeout(),
+		IdleTimeout:       config.HTTPIdleTimeout(),
+		MaxHeaderBytes:    config.HTTPMaxHeaderBytes,
+	}
+
+	// Start admin server
+	adminSrv := httpserver.NewServer(config.AdminAddr(), adminserver.NewHandler(checker, s, adminTokens, audit), serverOpts)
 	go func() {
-		if err := tcpSrv.Serve(tcpAddr); err != nil {
-			slog.Error("tcp server failed", "error", err)
+		slog.Info("starting admin server", "addr", adminSrv.Addr)
+		if err := adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("admin server failed", "error", err)
 			os.Exit(1)
 		}
 	}()
 
-	// Start HTTP server
-	slog.Info("starting http server", "addr", config.HTTPAddr)
-	handler := httpserver.NewHandler(s)
-	if err := http.ListenAndServe(config.HTTPAddr, handler); err != nil {
-		slog.Error("http server failed", "error", err)
-		os.Exit(1)
+	// Start TCP and SSH servers
+	tcpCtx, stopTCP := context.WithCancel(context.Background())
+	var tcpDone sync.WaitGroup
+	serveTCP := func(name string, serve func() error) {
+		tcpDone.Add(1)
+		go func() {
+			defer tcpDone.Done()
+			if err := serve(); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error(name+" server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	serveTCP("tcp", func() error { return tcpSrv.Serve(tcpCtx, config.TCPHost+":"+config.TCPPort) })
+	if certManager != nil {
+		serveTCP("tcp", func() error { return tcpSrv.ServeTLS(tcpCtx, config.TCPTLSAddr(), certManager.TLSConfig()) })
+	}
+	if sshSrv != nil {
+		serveTCP("ssh", func() error { return sshSrv.Serve(tcpCtx, config.SSHAddr()) })
+	}
+
+	// Start HTTP servers
+	handler := httpserver.NewHandler(pastes, proxies)
+	plainOpts := serverOpts
+	plainOpts.H2C = config.HTTPH2C()
+	var httpsSrv *http.Server
+	if certManager != nil {
+		httpsSrv = httpserver.NewServer(config.HTTPSAddr(), handler, serverOpts)
+		httpsSrv.TLSConfig = certManager.TLSConfig()
+		handler = certManager.HTTPHandler(handler)
+		go func() {
+			slog.Info("starting https server", "addr", httpsSrv.Addr)
+			if err := httpsSrv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("https server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	httpSrv := httpserver.NewServer(config.HTTPAddr, handler, plainOpts)
+	go func() {
+		slog.Info("starting http server", "addr", httpSrv.Addr, "h2c", plainOpts.H2C)
+		if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("http server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	// Fail readiness first so load balancers drain us, then stop accepting work
+	slog.Info("shutting down", "delay", config.ShutdownDelay())
+	checker.Shutdown()
+	time.Sleep(config.ShutdownDelay())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	stopTCP()
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("http server shutdown failed", "error", err)
+	}
+	if httpsSrv != nil {
+		if err := httpsSrv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("https server shutdown failed", "error", err)
+		}
+	}
+	tcpStopped := make(chan struct{})
+	go func() {
+		tcpDone.Wait()
+		close(tcpStopped)
+	}()
+	select {
+	case <-tcpStopped:
+	case <-shutdownCtx.Done():
+		slog.Error("tcp and ssh server shutdown timed out")
+	}
+	if err := adminSrv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("admin server shutdown failed", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("tracing shutdown failed", "error", err)
 	}
+	slog.Info("shutdown complete")
 }
@@ -0,0 +1,182 @@
+package acl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tombowditch/pastey-serv/internal/reload"
+)
+
+// Operation is the kind of access being checked.
+type Operation string
+
+const (
+	OpCreate Operation = "create"
+	OpRead   Operation = "read"
+)
+
+// Rules is the document format of an ACL file. Each entry is a CIDR or a bare IP.
+type Rules struct {
+	Create Lists `json:"create"`
+	Read   Lists `json:"read"`
+}
+
+// Lists holds the allow and deny lists for one operation.
+// Deny entries take precedence; a non-empty allow list denies everything it doesn't match.
+type Lists struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+type prefixes []netip.Prefix
+
+func (ps prefixes) contains(addr netip.Addr) bool {
+	for _, p := range ps {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type compiledLists struct {
+	allow, deny prefixes
+}
+
+func (l compiledLists) allowed(addr netip.Addr) bool {
+	if l.deny.contains(addr) {
+		return false
+	}
+	return len(l.allow) == 0 || l.allow.contains(addr)
+}
+
+type policy struct {
+	create, read compiledLists
+}
+
+// List checks client IPs against allow/deny lists that can be replaced at runtime.
+// The zero value allows everything. It is safe for concurrent use.
+type List struct {
+	policy atomic.Pointer[policy]
+}
+
+// Allowed reports whether ip may perform op. Unparseable addresses only pass
+// when the operation has no allow list.
+func (l *List) Allowed(op Operation, ip string) bool {
+	p := l.policy.Load()
+	if p == nil {
+		return true
+	}
+	lists := p.create
+	if op == OpRead {
+		lists = p.read
+	}
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return len(lists.allow) == 0
+	}
+	return lists.allowed(addr.Unmap())
+}
+
+// SetRules atomically replaces the lists. On error the current lists are kept.
+func (l *List) SetRules(r Rules) error {
+	var p policy
+	var err error
+	if p.create, err = compileLists(r.Create); err != nil {
+		return fmt.Errorf("create: %w", err)
+	}
+	if p.read, err = compileLists(r.Read); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	l.policy.Store(&p)
+	return nil
+}
+
+func compileLists(ls Lists) (compiledLists, error) {
+	var c compiledLists
+	var err error
+	if c.allow, err = parsePrefixes(ls.Allow); err != nil {
+		return c, err
+	}
+	if c.deny, err = parsePrefixes(ls.Deny); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+func parsePrefixes(entries []string) (prefixes, error) {
+	ps := make(prefixes, 0, len(entries))
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if !strings.Contains(e, "/") {
+			addr, err := netip.ParseAddr(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", e)
+			}
+			ps = append(ps, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", e)
+		}
+		if p.Addr().Is4In6() {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		ps = append(ps, p.Masked())
+	}
+	return ps, nil
+}
+
+// Reload reads the JSON rules file at path and installs it if it changed since last.
+// It returns the document that is now active.
+func (l *List) Reload(ctx context.Context, path string, last []byte) ([]byte, error) {
+	return l.loader(path).Reload(ctx, last)
+}
+
+// Watch polls path every interval and installs changed rules, until ctx is done.
+// Invalid files are logged and the previous rules stay active.
+func (l *List) Watch(ctx context.Context, path string, interval time.Duration, last []byte) {
+	l.loader(path).Watch(ctx, interval, last, func(err error) {
+		slog.ErrorContext(ctx, "reloading access control lists failed", "path", path, "error", err)
+	})
+}
+
+func (l *List) loader(path string) reload.Loader[Rules] {
+	return reload.Loader[Rules]{
+		Load: func(context.Context) ([]byte, error) {
+			return os.ReadFile(path)
+		},
+		Parse: parseRules,
+		Install: func(ctx context.Context, r Rules) error {
+			if err := l.SetRules(r); err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "loaded access control lists", "path", path,
+				"create_allow", len(r.Create.Allow), "create_deny", len(r.Create.Deny),
+				"read_allow", len(r.Read.Allow), "read_deny", len(r.Read.Deny),
+			)
+			return nil
+		},
+	}
+}
+
+// parseRules decodes an ACL document, rejecting unknown fields.
+func parseRules(data []byte) (Rules, error) {
+	var r Rules
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&r); err != nil {
+		return Rules{}, fmt.Errorf("parsing ACL: %w", err)
+	}
+	return r, nil
+}
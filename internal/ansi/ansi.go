@@ -0,0 +1,213 @@
+// Package ansi makes terminal output safe to show: it removes escape
+// sequences that could retitle or reprogram a viewer's terminal, or all of
+// them, and renders colors as HTML.
+package ansi
+
+// Mode selects what a Filter keeps.
+type Mode int
+
+const (
+	// Sanitize keeps color and style (SGR) sequences and removes every other
+	// escape sequence and control character except tab, newline and carriage
+	// return.
+	Sanitize Mode = iota
+	// Strip removes all escape sequences, leaving plain text.
+	Strip
+)
+
+// maxCSI bounds a control sequence's parameters. Longer sequences aren't
+// colors and are removed.
+const maxCSI = 64
+
+// maxCommand bounds how much of an OSC, DCS or similar command string is
+// removed while waiting for its terminator, so one left unterminated can't
+// swallow the rest of a paste.
+const maxCommand = 4096
+
+type state uint8
+
+const (
+	ground state = iota
+	// escape follows ESC.
+	escape
+	// escapeIntermediate is within an escape sequence such as ESC ( B.
+	escapeIntermediate
+	// csi is within a control sequence, ESC [ or 8-bit CSI.
+	csi
+	// command is within OSC, DCS, SOS, PM or APC, which run until BEL, ST,
+	// a newline or maxCommand bytes.
+	command
+	// c1 follows 0xC2, the first byte of UTF-8 encoded C1 controls.
+	c1
+	// commandC1 follows 0xC2 within a command, which may start an 8-bit ST.
+	commandC1
+)
+
+// Filter removes escape sequences from text that may arrive in pieces, such
+// as a live paste, holding back sequences split across them. The zero value
+// sanitizes.
+type Filter struct {
+	mode  Mode
+	state state
+	// params collects the current control sequence's parameter and
+	// intermediate bytes.
+	params   []byte
+	overflow bool
+	// commandLen counts the current command string's bytes.
+	commandLen int
+}
+
+// NewFilter returns a Filter for the given mode.
+func NewFilter(mode Mode) *Filter {
+	return &Filter{mode: mode}
+}
+
+// Clean returns s with escape sequences removed according to mode.
+func Clean(s string, mode Mode) string {
+	f := NewFilter(mode)
+	return string(f.flush(f.Write(nil, []byte(s))))
+}
+
+// flush appends what is held back at the end of complete text: a final
+// 0xC2 starts no control, as nothing follows it.
+func (f *Filter) flush(dst []byte) []byte {
+	if f.state == c1 {
+		f.state = ground
+		dst = append(dst, 0xc2)
+	}
+	return dst
+}
+
+// Write appends the filtered form of data to dst and returns it. A sequence
+// left incomplete at the end of data is completed, or discarded, by the next
+// call.
+func (f *Filter) Write(dst, data []byte) []byte {
+	for _, b := range data {
+		switch f.state {
+		case ground:
+			dst = f.ground(dst, b)
+		case c1:
+			f.state = ground
+			switch {
+			case b == 0x9b:
+				f.startCSI()
+			case b == 0x90 || b == 0x98 || b == 0x9d || b == 0x9e || b == 0x9f:
+				f.startCommand()
+			case b >= 0x80 && b <= 0x9f:
+				// Another C1 control, removed
+			default:
+				dst = f.ground(append(dst, 0xc2), b)
+			}
+		case escape:
+			switch {
+			case b == '[':
+				f.startCSI()
+			case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+				f.startCommand()
+			case b >= 0x20 && b <= 0x2f:
+				f.state = escapeIntermediate
+			case b == 0x1b:
+				// ESC ESC: the first is abandoned
+			default:
+				f.state = ground
+			}
+		case escapeIntermediate:
+			switch {
+			case b >= 0x20 && b <= 0x2f:
+			case b == 0x1b:
+				f.state = escape
+			default:
+				f.state = ground
+			}
+		case csi:
+			switch {
+			case b >= 0x40 && b <= 0x7e:
+				f.state = ground
+				if f.mode == Sanitize && b == 'm' && !f.overflow && isSGR(f.params) {
+					dst = append(dst, 0x1b, '[')
+					dst = append(dst, f.params...)
+					dst = append(dst, 'm')
+				}
+			case b >= 0x20 && b <= 0x3f:
+				if len(f.params) < maxCSI {
+					f.params = append(f.params, b)
+				} else {
+					f.overflow = true
+				}
+			case b == 0x1b:
+				f.state = escape
+			default:
+				// Anything else cancels the sequence
+				f.state = ground
+				dst = f.ground(dst, b)
+			}
+		case command:
+			dst = f.command(dst, b)
+		case commandC1:
+			f.state = command
+			if b == 0x9c {
+				f.state = ground
+			} else {
+				dst = f.command(dst, b)
+			}
+		}
+	}
+	return dst
+}
+
+func (f *Filter) startCSI() {
+	f.state = csi
+	f.params = f.params[:0]
+	f.overflow = false
+}
+
+func (f *Filter) startCommand() {
+	f.state = command
+	f.commandLen = 0
+}
+
+// command handles a byte within a command string.
+func (f *Filter) command(dst []byte, b byte) []byte {
+	f.commandLen++
+	switch {
+	case b == 0x07 || b == 0x18 || b == 0x1a:
+		f.state = ground
+	case b == 0x1b:
+		// Either ST (ESC \) or a new sequence; both end the command
+		f.state = escape
+	case b == '\n' || f.commandLen > maxCommand:
+		// Never terminated, so what follows is shown as text
+		f.state = ground
+		dst = f.ground(dst, b)
+	case b == 0xc2:
+		f.state = commandC1
+	}
+	return dst
+}
+
+// ground handles a byte outside any escape sequence. Raw bytes 0x80 to 0x9f
+// are kept: terminals in UTF-8 mode don't treat them as C1 controls, and they
+// are text in Latin-1, cp1252 or binary pastes.
+func (f *Filter) ground(dst []byte, b byte) []byte {
+	switch {
+	case b == 0x1b:
+		f.state = escape
+	case b == 0xc2:
+		f.state = c1
+	case b == '\t' || b == '\n' || b == '\r' || (b >= 0x20 && b != 0x7f):
+		dst = append(dst, b)
+	}
+	return dst
+}
+
+// isSGR reports whether CSI parameters followed by 'm' select graphic
+// rendition: only digits and separators, with no private markers or
+// intermediates.
+func isSGR(params []byte) bool {
+	for _, b := range params {
+		if (b < '0' || b > '9') && b != ';' && b != ':' {
+			return false
+		}
+	}
+	return true
+}
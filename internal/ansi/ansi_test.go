@@ -0,0 +1,119 @@
+package ansi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	longSGR := "\x1b[" + strings.Repeat("1;", maxCSI) + "31m"
+	longOSC := "\x1b]" + strings.Repeat("x", maxCommand)
+	tests := []struct {
+		name     string
+		input    string
+		sanitize string
+		strip    string
+	}{
+		{name: "plain", input: "hello\tworld\r\n", sanitize: "hello\tworld\r\n", strip: "hello\tworld\r\n"},
+		{name: "empty", input: "", sanitize: "", strip: ""},
+		{name: "utf-8", input: "héllo ‛ 世界 🙂", sanitize: "héllo ‛ 世界 🙂", strip: "héllo ‛ 世界 🙂"},
+		{name: "sgr", input: "\x1b[1;31mred\x1b[0m", sanitize: "\x1b[1;31mred\x1b[0m", strip: "red"},
+		{name: "sgr reset", input: "\x1b[m", sanitize: "\x1b[m", strip: ""},
+		{name: "sgr colon", input: "\x1b[38:5:208mx", sanitize: "\x1b[38:5:208mx", strip: "x"},
+		{name: "cursor movement", input: "a\x1b[2J\x1b[Hb\x1b[10;20fc", sanitize: "abc", strip: "abc"},
+		{name: "private mode", input: "a\x1b[?1049hb", sanitize: "ab", strip: "ab"},
+		{name: "private sgr", input: "a\x1b[>4;2mb", sanitize: "ab", strip: "ab"},
+		{name: "intermediate sgr", input: "a\x1b[1 mb", sanitize: "ab", strip: "ab"},
+		{name: "osc title bel", input: "a\x1b]0;pwned\x07b", sanitize: "ab", strip: "ab"},
+		{name: "osc title st", input: "a\x1b]2;pwned\x1b\\b", sanitize: "ab", strip: "ab"},
+		{name: "osc hyperlink", input: "\x1b]8;;http://x\x1b\\link\x1b]8;;\x1b\\", sanitize: "link", strip: "link"},
+		{name: "dcs", input: "a\x1bP+q544e\x1b\\b", sanitize: "ab", strip: "ab"},
+		{name: "apc pm sos", input: "a\x1b_x\x1b\\\x1b^y\x1b\\\x1bXz\x1b\\b", sanitize: "ab", strip: "ab"},
+		{name: "command cancelled", input: "a\x1b]0;x\x18b", sanitize: "ab", strip: "ab"},
+		{name: "charset", input: "a\x1b(0b\x1b(Bc", sanitize: "abc", strip: "abc"},
+		{name: "two-byte escape", input: "a\x1bcb\x1b7c", sanitize: "abc", strip: "abc"},
+		{name: "escape escape", input: "a\x1b\x1b[31mb", sanitize: "a\x1b[31mb", strip: "ab"},
+		{name: "csi cancelled by control", input: "a\x1b[31\nb", sanitize: "a\nb", strip: "a\nb"},
+		{name: "csi restarted", input: "a\x1b[3\x1b[32mb", sanitize: "a\x1b[32mb", strip: "ab"},
+		{name: "c0 controls", input: "a\x00\x07\x08\x0b\x0c\x7fb", sanitize: "ab", strip: "ab"},
+		{name: "utf-8 c1 csi", input: "a\xc2\x9b31mb", sanitize: "a\x1b[31mb", strip: "ab"},
+		{name: "utf-8 c1 osc", input: "a\xc2\x9d0;t\xc2\x9cb", sanitize: "ab", strip: "ab"},
+		{name: "utf-8 c1 other", input: "a\xc2\x85b", sanitize: "ab", strip: "ab"},
+		{name: "utf-8 after c2", input: "\xc2\xa0\xc2\xbf", sanitize: "\xc2\xa0\xc2\xbf", strip: "\xc2\xa0\xc2\xbf"},
+		{name: "8-bit csi kept", input: "a\x9b2Jb", sanitize: "a\x9b2Jb", strip: "a\x9b2Jb"},
+		{name: "8-bit osc kept", input: "a\x9d0;x\x07b", sanitize: "a\x9d0;xb", strip: "a\x9d0;xb"},
+		{name: "8-bit other c1 kept", input: "a\x85\x8d\x8eb", sanitize: "a\x85\x8d\x8eb", strip: "a\x85\x8d\x8eb"},
+		{name: "latin-1", input: "caf\xe9 \xa3 \xc2\xb0", sanitize: "caf\xe9 \xa3 \xc2\xb0", strip: "caf\xe9 \xa3 \xc2\xb0"},
+		{name: "cp1252 quotes", input: "\x93quoted\x94 \x80", sanitize: "\x93quoted\x94 \x80", strip: "\x93quoted\x94 \x80"},
+		{name: "binary", input: "\x00\x90\x01\x9d\xff\x9f\nrest", sanitize: "\x90\x9d\xff\x9f\nrest", strip: "\x90\x9d\xff\x9f\nrest"},
+		{name: "continuation byte 9b", input: "\xe2\x80\x9b", sanitize: "\xe2\x80\x9b", strip: "\xe2\x80\x9b"},
+		{name: "continuation byte 9d", input: "\xf0\x9d\x90\x80", sanitize: "\xf0\x9d\x90\x80", strip: "\xf0\x9d\x90\x80"},
+		{name: "truncated utf-8 then c1", input: "\xe2\x80a\x9b2Jb", sanitize: "\xe2\x80a\x9b2Jb", strip: "\xe2\x80a\x9b2Jb"},
+		{name: "truncated escape", input: "abc\x1b", sanitize: "abc", strip: "abc"},
+		{name: "truncated csi", input: "abc\x1b[31", sanitize: "abc", strip: "abc"},
+		{name: "truncated osc", input: "abc\x1b]0;title", sanitize: "abc", strip: "abc"},
+		{name: "truncated c2", input: "abc\xc2", sanitize: "abc\xc2", strip: "abc\xc2"},
+		{name: "unterminated osc ends at newline", input: "a\x1b]0;x\nrest", sanitize: "a\nrest", strip: "a\nrest"},
+		{name: "unterminated utf-8 osc ends at newline", input: "a\xc2\x9d0;x\nrest", sanitize: "a\nrest", strip: "a\nrest"},
+		{name: "newline after c2 in osc", input: "a\x1b]0;\xc2\nrest", sanitize: "a\nrest", strip: "a\nrest"},
+		{name: "oversized osc", input: "a" + longOSC + "rest", sanitize: "arest", strip: "arest"},
+		{name: "oversized sgr", input: "a" + longSGR + "b", sanitize: "ab", strip: "ab"},
+		{name: "longest sgr", input: "\x1b[" + strings.Repeat("1", maxCSI) + "mx", sanitize: "\x1b[" + strings.Repeat("1", maxCSI) + "mx", strip: "x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Clean(tt.input, Sanitize); got != tt.sanitize {
+				t.Errorf("Clean(%q, Sanitize) = %q, want %q", tt.input, got, tt.sanitize)
+			}
+			if got := Clean(tt.input, Strip); got != tt.strip {
+				t.Errorf("Clean(%q, Strip) = %q, want %q", tt.input, got, tt.strip)
+			}
+
+			// Content arriving in pieces is filtered the same
+			for _, mode := range []Mode{Sanitize, Strip} {
+				f := NewFilter(mode)
+				var got []byte
+				for i := 0; i < len(tt.input); i++ {
+					got = f.Write(got, []byte{tt.input[i]})
+				}
+				got = f.flush(got)
+				if want := Clean(tt.input, mode); string(got) != want {
+					t.Errorf("byte-by-byte mode %d = %q, want %q", mode, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHTML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "escaped", input: "<script>&", want: "&lt;script&gt;&amp;"},
+		{name: "basic color", input: "\x1b[31mred", want: `<span style="color:#cd3131">red</span>`},
+		{name: "bright background", input: "\x1b[102mx", want: "background:#23d18b"},
+		{name: "256 colors", input: "\x1b[38;5;196mx", want: "color:#ff0000"},
+		{name: "truecolor", input: "\x1b[38;2;1;2;3mx", want: "color:#010203"},
+		{name: "truncated 256 colors", input: "\x1b[38;5mx", want: "x"},
+		{name: "out of range truecolor", input: "\x1b[38;2;999;0;0mx", want: "x"},
+		{name: "title removed", input: "a\x1b]0;pwned\x07b", want: "ab"},
+		{name: "invalid utf-8", input: "a\xffb", want: "a�b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(HTML("<title>", tt.input))
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("HTML(%q) = %q, want it to contain %q", tt.input, got, tt.want)
+			}
+			if !strings.Contains(got, "<title>&lt;title&gt;</title>") {
+				t.Errorf("HTML title not escaped: %q", got)
+			}
+			if strings.Contains(got, "pwned") || strings.Contains(got, "\x1b") {
+				t.Errorf("HTML(%q) kept an escape sequence: %q", tt.input, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package ansi
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+const (
+	foreground = "#d4d4d4"
+	background = "#1e1e1e"
+)
+
+// palette holds the 16 basic and bright colors.
+var palette = [16]string{
+	"#000000", "#cd3131", "#0dbc79", "#e5e510", "#2472c8", "#bc3fbc", "#11a8cd", "#e5e5e5",
+	"#666666", "#f14c4c", "#23d18b", "#f5f543", "#3b8eea", "#d670d6", "#29b8db", "#ffffff",
+}
+
+// style is the graphic rendition set by SGR sequences.
+type style struct {
+	fg, bg                                          string
+	bold, faint, italic, underline, inverse, strike bool
+}
+
+// HTML renders terminal output as a standalone HTML page, showing colors and
+// styles. Other escape sequences are removed as by Sanitize.
+func HTML(title, s string) []byte {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>")
+	b.WriteString(html.EscapeString(title))
+	b.WriteString("</title><style>body{margin:0;background:" + background + ";color:" + foreground +
+		"}pre{margin:0;padding:1em;font:13px/1.4 monospace;white-space:pre-wrap;word-break:break-all}</style></head><body><pre>")
+
+	var st style
+	s = strings.ToValidUTF8(Clean(s, Sanitize), "�")
+	for s != "" {
+		i := strings.Index(s, "\x1b[")
+		if i < 0 {
+			i = len(s)
+		}
+		if i > 0 {
+			st.write(&b, s[:i])
+		}
+		s = s[i:]
+		if s == "" {
+			break
+		}
+		// Clean leaves only complete SGR sequences
+		end := strings.IndexByte(s, 'm')
+		st.apply(s[2:end])
+		s = s[end+1:]
+	}
+
+	b.WriteString("</pre></body></html>\n")
+	return []byte(b.String())
+}
+
+// write writes text in the style.
+func (st style) write(b *strings.Builder, text string) {
+	css := st.css()
+	if css == "" {
+		b.WriteString(html.EscapeString(text))
+		return
+	}
+	b.WriteString(`<span style="` + css + `">`)
+	b.WriteString(html.EscapeString(text))
+	b.WriteString("</span>")
+}
+
+func (st style) css() string {
+	fg, bg := st.fg, st.bg
+	if st.inverse {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = background
+		}
+		if bg == "" {
+			bg = foreground
+		}
+	}
+
+	var css []string
+	if fg != "" {
+		css = append(css, "color:"+fg)
+	}
+	if bg != "" {
+		css = append(css, "background:"+bg)
+	}
+	if st.bold {
+		css = append(css, "font-weight:bold")
+	}
+	if st.faint {
+		css = append(css, "opacity:.7")
+	}
+	if st.italic {
+		css = append(css, "font-style:italic")
+	}
+	switch {
+	case st.underline && st.strike:
+		css = append(css, "text-decoration:underline line-through")
+	case st.underline:
+		css = append(css, "text-decoration:underline")
+	case st.strike:
+		css = append(css, "text-decoration:line-through")
+	}
+	return strings.Join(css, ";")
+}
+
+// apply updates the style from an SGR sequence's parameters.
+func (st *style) apply(params string) {
+	codes := strings.FieldsFunc(params, func(r rune) bool { return r == ';' || r == ':' })
+	if len(codes) == 0 {
+		*st = style{}
+		return
+	}
+	for i := 0; i < len(codes); i++ {
+		code, _ := strconv.Atoi(codes[i])
+		switch {
+		case code == 0:
+			*st = style{}
+		case code == 1:
+			st.bold = true
+		case code == 2:
+			st.faint = true
+		case code == 3:
+			st.italic = true
+		case code == 4:
+			st.underline = true
+		case code == 7:
+			st.inverse = true
+		case code == 9:
+			st.strike = true
+		case code == 22:
+			st.bold, st.faint = false, false
+		case code == 23:
+			st.italic = false
+		case code == 24:
+			st.underline = false
+		case code == 27:
+			st.inverse = false
+		case code == 29:
+			st.strike = false
+		case code >= 30 && code <= 37:
+			st.fg = palette[code-30]
+		case code == 38:
+			st.fg, i = extendedColor(codes, i)
+		case code == 39:
+			st.fg = ""
+		case code >= 40 && code <= 47:
+			st.bg = palette[code-40]
+		case code == 48:
+			st.bg, i = extendedColor(codes, i)
+		case code == 49:
+			st.bg = ""
+		case code >= 90 && code <= 97:
+			st.fg = palette[code-90+8]
+		case code >= 100 && code <= 107:
+			st.bg = palette[code-100+8]
+		}
+	}
+}
+
+// extendedColor reads a 256-color (5;n) or RGB (2;r;g;b) color following
+// codes[i], returning it and the index of its last parameter.
+func extendedColor(codes []string, i int) (string, int) {
+	if i+1 >= len(codes) {
+		return "", i
+	}
+	switch codes[i+1] {
+	case "5":
+		if i+2 >= len(codes) {
+			return "", i + 1
+		}
+		n, _ := strconv.Atoi(codes[i+2])
+		return color256(n), i + 2
+	case "2":
+		if i+4 >= len(codes) {
+			return "", len(codes)
+		}
+		var rgb [3]int
+		for j := range rgb {
+			v, _ := strconv.Atoi(codes[i+2+j])
+			rgb[j] = min(max(v, 0), 255)
+		}
+		return fmt.Sprintf("#%02x%02x%02x", rgb[0], rgb[1], rgb[2]), i + 4
+	default:
+		return "", i + 1
+	}
+}
+
+// color256 converts an xterm 256-color index.
+func color256(n int) string {
+	switch {
+	case n < 0 || n > 255:
+		return ""
+	case n < 16:
+		return palette[n]
+	case n < 232:
+		levels := [6]int{0, 95, 135, 175, 215, 255}
+		n -= 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6], levels[n%6])
+	default:
+		v := 8 + (n-232)*10
+		return fmt.Sprintf("#%02x%02x%02x", v, v, v)
+	}
+}
@@ -0,0 +1,188 @@
+// Package certs provides certificates for the TLS listeners, either from
+// files on disk that are reloaded when they change or from an ACME CA.
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config describes where certificates come from. Either CertFile and KeyFile
+// or ACMEDomains must be set.
+type Config struct {
+	CertFile string
+	KeyFile  string
+
+	// ACMEDomains are the host names to obtain certificates for automatically.
+	ACMEDomains []string
+	// ACMEDirectoryURL is the CA's directory; empty means Let's Encrypt.
+	ACMEDirectoryURL string
+	// ACMECAFile is a PEM bundle to trust when talking to the CA, for private
+	// or test CAs such as Pebble.
+	ACMECAFile   string
+	ACMECacheDir string
+	ACMEEmail    string
+}
+
+// Manager supplies certificates to TLS listeners.
+type Manager struct {
+	files *fileCert
+	acme  *autocert.Manager
+}
+
+// New creates a manager from cfg, loading file certificates immediately so
+// configuration errors are reported at startup.
+func New(cfg Config) (*Manager, error) {
+	switch {
+	case len(cfg.ACMEDomains) > 0:
+		am, err := newACME(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &Manager{acme: am}, nil
+	case cfg.CertFile != "" && cfg.KeyFile != "":
+		fc := &fileCert{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+		if err := fc.load(); err != nil {
+			return nil, err
+		}
+		return &Manager{files: fc}, nil
+	default:
+		return nil, errors.New("either a certificate and key file or ACME domains are required")
+	}
+}
+
+func newACME(cfg Config) (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+	if cfg.ACMECAFile != "" {
+		pem, err := os.ReadFile(cfg.ACMECAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading ACME CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.ACMECAFile)
+		}
+		client.HTTPClient = &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		}}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACMECacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.ACMEDomains...),
+		Client:     client,
+		Email:      cfg.ACMEEmail,
+	}, nil
+}
+
+// TLSConfig returns a server configuration that uses the manager's certificates.
+// With ACME it also answers TLS-ALPN-01 challenges.
+func (m *Manager) TLSConfig() *tls.Config {
+	if m.acme != nil {
+		cfg := m.acme.TLSConfig()
+		cfg.MinVersion = tls.VersionTLS12
+		return cfg
+	}
+	return &tls.Config{
+		GetCertificate: m.files.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}
+
+// HTTPHandler wraps the plain HTTP handler so it answers ACME HTTP-01
+// challenges. Without ACME it returns h unchanged.
+func (m *Manager) HTTPHandler(h http.Handler) http.Handler {
+	if m.acme != nil {
+		return m.acme.HTTPHandler(h)
+	}
+	return h
+}
+
+// Watch reloads file certificates on SIGHUP and whenever the files' modification
+// times change (checked every interval), until ctx is done. ACME certificates
+// are renewed by the manager itself, so Watch returns immediately for them.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration) {
+	if m.files == nil {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			m.files.reload(ctx, "signal")
+		case <-t.C:
+			if m.files.changed() {
+				m.files.reload(ctx, "file change")
+			}
+		}
+	}
+}
+
+// fileCert is a certificate loaded from disk that can be replaced at runtime.
+type fileCert struct {
+	certFile, keyFile string
+	cert              atomic.Pointer[tls.Certificate]
+	// modTimes of the cert and key when last loaded; only used by Watch.
+	modTimes [2]time.Time
+}
+
+func (f *fileCert) load() error {
+	modTimes := f.stat()
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading certificate: %w", err)
+	}
+	f.cert.Store(&cert)
+	f.modTimes = modTimes
+	return nil
+}
+
+// reload loads the files, keeping the current certificate if they are invalid,
+// e.g. because only one of them has been replaced so far.
+func (f *fileCert) reload(ctx context.Context, trigger string) {
+	if err := f.load(); err != nil {
+		slog.ErrorContext(ctx, "reloading tls certificate failed", "trigger", trigger, "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "reloaded tls certificate", "trigger", trigger, "cert", f.certFile)
+}
+
+func (f *fileCert) changed() bool {
+	return f.stat() != f.modTimes
+}
+
+func (f *fileCert) stat() [2]time.Time {
+	var times [2]time.Time
+	for i, path := range []string{f.certFile, f.keyFile} {
+		if fi, err := os.Stat(path); err == nil {
+			times[i] = fi.ModTime()
+		}
+	}
+	return times
+}
+
+func (f *fileCert) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return f.cert.Load(), nil
+}
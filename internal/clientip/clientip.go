@@ -0,0 +1,157 @@
+// Package clientip determines the address of the client behind trusted
+// reverse proxies and load balancers.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver finds the client address of requests and connections, trusting
+// forwarding information only when it comes from a configured proxy.
+// The zero value trusts no proxies.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// NewResolver creates a resolver that trusts the given proxies, each a CIDR
+// or a bare address.
+func NewResolver(proxies []string) (*Resolver, error) {
+	r := &Resolver{}
+	for _, p := range proxies {
+		prefix, err := parsePrefix(p)
+		if err != nil {
+			return nil, err
+		}
+		r.trusted = append(r.trusted, prefix)
+	}
+	return r, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid proxy address %q", s)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid proxy CIDR %q", s)
+	}
+	return p.Masked(), nil
+}
+
+// Trusted reports whether ip belongs to a trusted proxy.
+func (r *Resolver) Trusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range r.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// FromRequest returns the client address of req. When the peer is a trusted
+// proxy, the Forwarded header (or else X-Forwarded-For, then X-Real-IP) is
+// walked from the right, skipping trusted proxies, so clients can't choose
+// their address by sending the header themselves.
+func (r *Resolver) FromRequest(req *http.Request) string {
+	ip := hostOnly(req.RemoteAddr)
+	if !r.Trusted(ip) {
+		return ip
+	}
+
+	var hops []string
+	if fwd := req.Header.Values("Forwarded"); len(fwd) > 0 {
+		hops = parseForwarded(fwd)
+	} else if xff := req.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		for _, v := range xff {
+			for _, hop := range strings.Split(v, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+	} else if xri := strings.TrimSpace(req.Header.Get("X-Real-IP")); xri != "" {
+		hops = []string{xri}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := normalize(hops[i])
+		if !ok {
+			// Garbage or an obfuscated identifier; nothing further left can be trusted
+			return ip
+		}
+		ip = hop
+		if !r.Trusted(ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// parseForwarded extracts the for= parameter of each element of RFC 7239
+// Forwarded headers, in order. Elements without one yield "".
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(elem, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = strings.Trim(val, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// normalize parses a forwarded hop, which may carry a port or be a
+// bracketed IPv6 address, into a canonical address string.
+func normalize(hop string) (string, bool) {
+	if addr, err := netip.ParseAddr(hop); err == nil {
+		return addr.Unmap().String(), true
+	}
+	if ap, err := netip.ParseAddrPort(hop); err == nil {
+		return ap.Addr().Unmap().String(), true
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]")); err == nil {
+		return addr.Unmap().String(), true
+	}
+	return "", false
+}
+
+// FromAddr returns the IP of a connection's address.
+func FromAddr(addr net.Addr) string {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		if ip, ok := netip.AddrFromSlice(tcp.IP); ok {
+			return ip.Unmap().String()
+		}
+	}
+	return hostOnly(addr.String())
+}
+
+// hostOnly strips the port from a host:port address, handling IPv6.
+func hostOnly(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return host
+}
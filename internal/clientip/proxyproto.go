@@ -0,0 +1,195 @@
+package clientip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxV1HeaderLength is the longest valid PROXY protocol v1 header, including CRLF.
+const maxV1HeaderLength = 107
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ErrNoProxyHeader is returned when a trusted proxy's connection doesn't start
+// with a PROXY protocol header.
+var ErrNoProxyHeader = errors.New("missing PROXY protocol header")
+
+// ProxyListener wraps l so that connections from trusted proxies must begin
+// with a HAProxy PROXY protocol v1 or v2 header, whose source address then
+// becomes the connection's RemoteAddr. Connections from other peers are
+// passed through untouched, so clients can't spoof a header.
+//
+// The header is read on first use rather than in Accept, so a slow proxy
+// doesn't hold up the accept loop; it must arrive within timeout.
+func (r *Resolver) ProxyListener(l net.Listener, timeout time.Duration) net.Listener {
+	return &proxyListener{Listener: l, resolver: r, timeout: timeout}
+}
+
+type proxyListener struct {
+	net.Listener
+	resolver *Resolver
+	timeout  time.Duration
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.resolver.Trusted(FromAddr(conn.RemoteAddr())) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+// proxyConn is a connection from a trusted proxy whose PROXY header is read lazily.
+type proxyConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+// Handshake reads the PROXY protocol header of conn if it came from a trusted
+// proxy, and is a no-op otherwise. Servers should call it before using
+// RemoteAddr so a missing or invalid header can be rejected. Connections
+// wrapped by TLS are unwrapped first.
+func Handshake(conn net.Conn) error {
+	if wrapped, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = wrapped.NetConn()
+	}
+	if pc, ok := conn.(*proxyConn); ok {
+		return pc.handshake()
+	}
+	return nil
+}
+
+func (c *proxyConn) handshake() error {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		defer c.Conn.SetReadDeadline(time.Time{})
+		c.remote, c.err = readProxyHeader(c.r)
+		if c.err != nil {
+			c.err = fmt.Errorf("proxy protocol: %w", c.err)
+		}
+	})
+	return c.err
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if err := c.handshake(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.handshake() == nil && c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader consumes a v1 or v2 header and returns the source address
+// it carries, or nil for LOCAL/UNKNOWN connections such as health checks.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, ErrNoProxyHeader
+	}
+	switch {
+	case bytes.Equal(sig, v2Signature):
+		return readV2(r)
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		return readV1(r)
+	default:
+		return nil, ErrNoProxyHeader
+	}
+}
+
+// readV1 parses "PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n".
+func readV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1HeaderLength {
+			return nil, errors.New("v1 header too long")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errors.New("malformed v1 header")
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil || addr.Is4() != (fields[1] == "TCP4") {
+		return nil, errors.New("invalid v1 source address")
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errors.New("invalid v1 source port")
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+// readV2 parses the binary v2 header, skipping any TLVs.
+func readV2(r *bufio.Reader) (net.Addr, error) {
+	hdr := make([]byte, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, errors.New("unsupported v2 version")
+	}
+	command, family := hdr[12]&0x0f, hdr[13]
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch command {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errors.New("unknown v2 command")
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errors.New("short v2 IPv4 address block")
+		}
+		addr := netip.AddrFrom4([4]byte(payload[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[8:10]))), nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errors.New("short v2 IPv6 address block")
+		}
+		addr := netip.AddrFrom16([16]byte(payload[0:16])).Unmap()
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[32:34]))), nil
+	default:
+		// UDP or unix sockets; keep the proxy's address
+		return nil, nil
+	}
+}
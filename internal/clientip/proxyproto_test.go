@@ -0,0 +1,112 @@
+package clientip
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// v2Header builds a v2 header with the given version/command and family
+// bytes around payload.
+func v2Header(verCmd, family byte, payload []byte) []byte {
+	h := append([]byte{}, v2Signature...)
+	h = append(h, verCmd, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(payload)))
+	return append(h, payload...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := []byte{
+		192, 0, 2, 1, // source
+		198, 51, 100, 1, // destination
+		0x30, 0x39, // source port 12345
+		0x01, 0xbb, // destination port 443
+	}
+	ipv6 := make([]byte, 36)
+	ipv6[0], ipv6[1], ipv6[15] = 0x20, 0x01, 0x01
+	binary.BigEndian.PutUint16(ipv6[32:], 8080)
+	mapped := make([]byte, 36)
+	copy(mapped[10:], []byte{0xff, 0xff, 203, 0, 113, 7})
+	binary.BigEndian.PutUint16(mapped[32:], 1)
+
+	tests := []struct {
+		name  string
+		input []byte
+		// want is the source address, or "" if the proxy's address is kept.
+		want    string
+		wantErr bool
+	}{
+		{name: "v1 tcp4", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\r\n"), want: "192.0.2.1:12345"},
+		{name: "v1 tcp6", input: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n"), want: "[2001:db8::1]:1"},
+		{name: "v1 unknown", input: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 unknown with addresses", input: []byte("PROXY UNKNOWN ffff:: ffff:: 1 2\r\n")},
+		{name: "v1 longest header", input: []byte("PROXY TCP6 ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff 65535 65535\r\n"), want: "[ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff]:65535"},
+		{name: "v1 too long", input: []byte("PROXY UNKNOWN " + strings.Repeat("x", maxV1HeaderLength) + "\r\n"), wantErr: true},
+		{name: "v1 without end", input: []byte(strings.Repeat("PROXY ", 100)), wantErr: true},
+		{name: "v1 truncated", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443"), wantErr: true},
+		{name: "v1 bare newline", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345 443\n"), wantErr: true},
+		{name: "v1 missing port", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 12345\r\n"), wantErr: true},
+		{name: "v1 extra field", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 1 2 3\r\n"), wantErr: true},
+		{name: "v1 unknown protocol", input: []byte("PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n"), wantErr: true},
+		{name: "v1 family mismatch", input: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 1 2\r\n"), wantErr: true},
+		{name: "v1 invalid address", input: []byte("PROXY TCP4 192.0.2.256 198.51.100.1 1 2\r\n"), wantErr: true},
+		{name: "v1 port out of range", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 65536 2\r\n"), wantErr: true},
+		{name: "v1 negative port", input: []byte("PROXY TCP4 192.0.2.1 198.51.100.1 -1 2\r\n"), wantErr: true},
+		{name: "v2 tcp4", input: v2Header(0x21, 0x11, ipv4), want: "192.0.2.1:12345"},
+		{name: "v2 tcp6", input: v2Header(0x21, 0x21, ipv6), want: "[2001::1]:8080"},
+		{name: "v2 tcp6 mapped ipv4", input: v2Header(0x21, 0x21, mapped), want: "203.0.113.7:1"},
+		{name: "v2 with tlvs", input: v2Header(0x21, 0x11, append(ipv4, 0x04, 0x00, 0x01, 0x00)), want: "192.0.2.1:12345"},
+		{name: "v2 local", input: v2Header(0x20, 0x00, nil)},
+		{name: "v2 udp", input: v2Header(0x21, 0x12, ipv4)},
+		{name: "v2 unix", input: v2Header(0x21, 0x31, make([]byte, 216))},
+		{name: "v2 version 1", input: v2Header(0x11, 0x11, ipv4), wantErr: true},
+		{name: "v2 unknown command", input: v2Header(0x22, 0x11, ipv4), wantErr: true},
+		{name: "v2 short ipv4 block", input: v2Header(0x21, 0x11, ipv4[:11]), wantErr: true},
+		{name: "v2 short ipv6 block", input: v2Header(0x21, 0x21, ipv6[:35]), wantErr: true},
+		{name: "v2 truncated fixed header", input: v2Header(0x21, 0x11, ipv4)[:14], wantErr: true},
+		{name: "v2 truncated payload", input: v2Header(0x21, 0x11, ipv4)[:20], wantErr: true},
+		{name: "v2 oversized length", input: append(append([]byte{}, v2Signature...), 0x21, 0x11, 0xff, 0xff, 1, 2, 3), wantErr: true},
+		{name: "signature only", input: v2Signature, wantErr: true},
+		{name: "partial signature", input: v2Signature[:6], wantErr: true},
+		{name: "empty", input: nil, wantErr: true},
+		{name: "no header", input: []byte("hello, this is a paste\n"), wantErr: true},
+		{name: "lowercase v1", input: []byte("proxy TCP4 192.0.2.1 198.51.100.1 1 2\r\n"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Client data follows valid headers; truncated ones end the stream
+			rest := "GET abc\n"
+			if tt.wantErr {
+				rest = ""
+			}
+			r := bufio.NewReader(bytes.NewReader(append(append([]byte{}, tt.input...), rest...)))
+			addr, err := readProxyHeader(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("readProxyHeader() = %v, want error", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readProxyHeader() error = %v", err)
+			}
+
+			got := ""
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != tt.want {
+				t.Errorf("readProxyHeader() = %q, want %q", got, tt.want)
+			}
+			// The header is consumed exactly, leaving the client's data
+			left := make([]byte, 64)
+			n, _ := r.Read(left)
+			if string(left[:n]) != rest {
+				t.Errorf("data after header = %q, want %q", left[:n], rest)
+			}
+		})
+	}
+}
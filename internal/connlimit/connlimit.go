@@ -0,0 +1,44 @@
+// Package connlimit caps concurrent connections from each client IP, for the
+// TCP and SSH servers.
+package connlimit
+
+import "sync"
+
+// PerIP caps the number of concurrent connections from each client IP.
+type PerIP struct {
+	max   int
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// NewPerIP returns a PerIP allowing max connections from each IP. A max of
+// zero means unlimited.
+func NewPerIP(max int) *PerIP {
+	return &PerIP{max: max, conns: make(map[string]int)}
+}
+
+// Acquire reserves a connection slot for ip, reporting false if it has none left.
+func (l *PerIP) Acquire(ip string) bool {
+	if l.max <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip] >= l.max {
+		return false
+	}
+	l.conns[ip]++
+	return true
+}
+
+// Release frees a slot reserved by Acquire.
+func (l *PerIP) Release(ip string) {
+	if l.max <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
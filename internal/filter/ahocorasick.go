@@ -0,0 +1,132 @@
+package filter
+
+import (
+	"cmp"
+	"slices"
+)
+
+// matcher finds every occurrence of a fixed set of byte patterns in a single
+// pass using an Aho-Corasick automaton, so scan time is linear in the input
+// regardless of how many patterns there are. Only the root has a full table
+// of transitions; other states list their trie edges and fall back along
+// failure links, so memory grows with the total length of the patterns.
+type matcher struct {
+	// root[b] is the state after reading byte b at the root.
+	root [256]int32
+	// edges[state] lists the trie edges out of state, sorted by byte. The
+	// root's are in root instead.
+	edges [][]edge
+	// fail[state] is the state for the longest proper suffix of state's
+	// path that is also a path in the trie.
+	fail []int32
+	// out[state] lists the patterns ending at state (including via suffix links).
+	out [][]int
+	// fold lowercases ASCII input before matching; patterns must already be lowercase.
+	fold bool
+}
+
+type edge struct {
+	b  byte
+	to int32
+}
+
+// newMatcher compiles patterns; the indices reported by scan refer to this slice.
+// Empty patterns are ignored.
+func newMatcher(patterns [][]byte, fold bool) *matcher {
+	m := &matcher{
+		edges: [][]edge{nil},
+		out:   [][]int{nil},
+		fold:  fold,
+	}
+	for b := range m.root {
+		m.root[b] = -1
+	}
+
+	// Build the trie
+	for i, p := range patterns {
+		if len(p) == 0 {
+			continue
+		}
+		state := int32(0)
+		for _, b := range p {
+			state = m.insert(state, b)
+		}
+		m.out[state] = append(m.out[state], i)
+	}
+
+	// Breadth-first, so each state's failure state is complete before it is
+	// used: set failure links and merge outputs
+	m.fail = make([]int32, len(m.out))
+	queue := make([]int32, 0, len(m.out))
+	for b, s := range m.root {
+		if s == -1 {
+			m.root[b] = 0
+		} else {
+			queue = append(queue, s)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		m.out[state] = append(m.out[state], m.out[m.fail[state]]...)
+		for _, e := range m.edges[state] {
+			m.fail[e.to] = m.step(m.fail[state], e.b)
+			queue = append(queue, e.to)
+		}
+	}
+	return m
+}
+
+// insert returns the trie state after b from state, adding it if needed.
+func (m *matcher) insert(state int32, b byte) int32 {
+	if state == 0 {
+		if m.root[b] == -1 {
+			m.root[b] = m.newState()
+		}
+		return m.root[b]
+	}
+	i, ok := slices.BinarySearchFunc(m.edges[state], b, func(e edge, b byte) int { return cmp.Compare(e.b, b) })
+	if ok {
+		return m.edges[state][i].to
+	}
+	next := m.newState()
+	m.edges[state] = slices.Insert(m.edges[state], i, edge{b: b, to: next})
+	return next
+}
+
+func (m *matcher) newState() int32 {
+	m.edges = append(m.edges, nil)
+	m.out = append(m.out, nil)
+	return int32(len(m.out) - 1)
+}
+
+// step returns the state after reading b in state.
+func (m *matcher) step(state int32, b byte) int32 {
+	for state != 0 {
+		edges := m.edges[state]
+		i, ok := slices.BinarySearchFunc(edges, b, func(e edge, b byte) int { return cmp.Compare(e.b, b) })
+		if ok {
+			return edges[i].to
+		}
+		state = m.fail[state]
+	}
+	return m.root[b]
+}
+
+// scan calls found for each pattern index that occurs in data, at most once per pattern.
+func (m *matcher) scan(data []byte, found func(pattern int)) {
+	seen := make(map[int]bool)
+	state := int32(0)
+	for _, b := range data {
+		if m.fold && b >= 'A' && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		state = m.step(state, b)
+		for _, p := range m.out[state] {
+			if !seen[p] {
+				seen[p] = true
+				found(p)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package filter
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// scanAll returns the sorted indices of the patterns m finds in data,
+// failing if any is reported twice.
+func scanAll(t *testing.T, m *matcher, data []byte) []int {
+	t.Helper()
+	var found []int
+	m.scan(data, func(p int) {
+		if slices.Contains(found, p) {
+			t.Errorf("pattern %d reported more than once", p)
+		}
+		found = append(found, p)
+	})
+	slices.Sort(found)
+	return found
+}
+
+// naive returns the sorted indices of the non-empty patterns in data.
+func naive(patterns [][]byte, data []byte, fold bool) []int {
+	if fold {
+		data = lowerASCII(data)
+	}
+	var found []int
+	for i, p := range patterns {
+		if len(p) > 0 && bytes.Contains(data, p) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+func TestMatcher(t *testing.T) {
+	classic := []string{"he", "she", "his", "hers"}
+	tests := []struct {
+		name     string
+		patterns []string
+		fold     bool
+		input    string
+		want     []int
+	}{
+		{name: "overlapping", patterns: classic, input: "ushers", want: []int{0, 1, 3}},
+		{name: "via failure link", patterns: classic, input: "ahishers", want: []int{0, 1, 2, 3}},
+		{name: "no match", patterns: classic, input: "xyz"},
+		{name: "empty input", patterns: classic, input: ""},
+		{name: "no patterns", patterns: nil, input: "anything"},
+		{name: "empty pattern ignored", patterns: []string{"", "a"}, input: "a", want: []int{1}},
+		{name: "only empty pattern", patterns: []string{""}, input: "abc"},
+		{name: "prefix of another", patterns: []string{"abc", "ab"}, input: "xabx", want: []int{1}},
+		{name: "suffix of another", patterns: []string{"abc", "bc"}, input: "abc", want: []int{0, 1}},
+		{name: "duplicate patterns", patterns: []string{"dup", "dup"}, input: "a dup", want: []int{0, 1}},
+		{name: "repeated matches", patterns: []string{"aa"}, input: "aaaaaa", want: []int{0}},
+		{name: "truncated at end", patterns: []string{"needle"}, input: "haystack needl"},
+		{name: "pattern longer than input", patterns: []string{"longpattern"}, input: "long"},
+		{name: "at start and end", patterns: []string{"st", "nd"}, input: "start and end", want: []int{0, 1}},
+		{name: "binary", patterns: []string{"\x00\xff", "\xde\xad\xbe\xef"}, input: "\x01\x00\xff\xde\xad\xbe", want: []int{0}},
+		{name: "case sensitive", patterns: []string{"secret"}, input: "SECRET"},
+		{name: "folded", patterns: []string{"secret"}, fold: true, input: "SeCrEt", want: []int{0}},
+		{name: "folded only ascii", patterns: []string{"é"}, fold: true, input: "É"},
+		{name: "folded leaves non-letters", patterns: []string{"[a]"}, fold: true, input: "{A}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns := make([][]byte, len(tt.patterns))
+			for i, p := range tt.patterns {
+				patterns[i] = []byte(p)
+			}
+			got := scanAll(t, newMatcher(patterns, tt.fold), []byte(tt.input))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("scan(%q) = %v, want %v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMatcherRandom checks the automaton against a naive search over many
+// small alphabets, where patterns overlap heavily, and one large input.
+func TestMatcherRandom(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	randBytes := func(n int, alphabet string) []byte {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = alphabet[rng.IntN(len(alphabet))]
+		}
+		return b
+	}
+
+	for i := 0; i < 500; i++ {
+		alphabet := "abAB\x00\xff"[:2+rng.IntN(5)]
+		patterns := make([][]byte, rng.IntN(20))
+		for j := range patterns {
+			patterns[j] = randBytes(rng.IntN(6), alphabet)
+		}
+		fold := rng.IntN(2) == 0
+		m := newMatcher(foldPatterns(patterns, fold), fold)
+		input := randBytes(rng.IntN(64), alphabet)
+		if got, want := scanAll(t, m, input), naive(foldPatterns(patterns, fold), input, fold); !slices.Equal(got, want) {
+			t.Fatalf("patterns %q, fold %v: scan(%q) = %v, want %v", patterns, fold, input, got, want)
+		}
+	}
+
+	// Many patterns over a large input
+	patterns := make([][]byte, 2000)
+	for i := range patterns {
+		patterns[i] = randBytes(4+rng.IntN(12), "abcdefgh")
+	}
+	input := randBytes(1<<18, "abcdefgh")
+	m := newMatcher(patterns, false)
+	if got, want := scanAll(t, m, input), naive(patterns, input, false); !slices.Equal(got, want) {
+		t.Fatalf("large scan found %d patterns, want %d", len(got), len(want))
+	}
+}
+
+// foldPatterns lowercases patterns for a folding matcher, as compile does.
+func foldPatterns(patterns [][]byte, fold bool) [][]byte {
+	if !fold {
+		return patterns
+	}
+	out := make([][]byte, len(patterns))
+	for i, p := range patterns {
+		out[i] = lowerASCII(p)
+	}
+	return out
+}
+
+// lowerASCII lowercases ASCII letters only, leaving invalid UTF-8 intact.
+func lowerASCII(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		out[i] = c
+	}
+	return out
+}
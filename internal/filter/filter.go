@@ -0,0 +1,252 @@
+package filter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tombowditch/pastey-serv/internal/config"
+)
+
+// Rule types.
+const (
+	// TypeLiteral matches an exact substring.
+	TypeLiteral = "literal"
+	// TypeCaseInsensitive matches a substring ignoring ASCII case.
+	TypeCaseInsensitive = "icase"
+	// TypeRegex matches a Go (RE2) regular expression.
+	TypeRegex = "regex"
+	// TypeBytes matches a hex-encoded byte sequence, e.g. "de ad be ef".
+	TypeBytes = "bytes"
+)
+
+// Actions, in increasing order of severity.
+const (
+	// ActionFlag stores the paste but records the match for moderators.
+	ActionFlag = "flag"
+	// ActionShortenTTL stores the paste with the rule's shorter TTL.
+	ActionShortenTTL = "shorten_ttl"
+	// ActionReject refuses to store the paste.
+	ActionReject = "reject"
+)
+
+var severity = map[string]int{
+	"":               0,
+	ActionFlag:       1,
+	ActionShortenTTL: 2,
+	ActionReject:     3,
+}
+
+// Rule is a single content filter rule as written in a rules file.
+type Rule struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"`
+	// TTL is the maximum paste lifetime for ActionShortenTTL, as a Go duration
+	// no longer than config.PasteTTL.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// Ruleset is the document format of a rules file.
+type Ruleset struct {
+	Rules []Rule `json:"rules"`
+}
+
+// maxPatternBytes bounds the combined length of all rules' patterns, which
+// sets how large the compiled matchers can grow. Rules over it are refused.
+const maxPatternBytes = 1 << 20
+
+// DefaultRules are used until rules are loaded from a source.
+var DefaultRules = []Rule{
+	{ID: "rdp-scan", Type: TypeLiteral, Pattern: "Cookie: mstshash=Administ", Action: ActionReject},
+	{ID: "php-cmd-exec", Type: TypeLiteral, Pattern: "-esystem('cmd /c echo .close", Action: ActionReject},
+	{ID: "vbs-dropper", Type: TypeLiteral, Pattern: "md /c echo Set xHttp=createobjec", Action: ActionReject},
+}
+
+// Match identifies a rule that matched.
+type Match struct {
+	RuleID string
+	Action string
+}
+
+// Verdict is the outcome of scanning a paste.
+type Verdict struct {
+	// Action is the most severe action of all matched rules, or "" if none matched.
+	Action string
+	// Matched lists every matched rule.
+	Matched []Match
+	// TTL is the shortest TTL of matched shorten_ttl rules.
+	TTL time.Duration
+}
+
+// compiledRule is a Rule ready for matching.
+type compiledRule struct {
+	Rule
+	ttl time.Duration
+}
+
+// ruleset is an immutable compiled set of rules.
+type ruleset struct {
+	rules   []compiledRule
+	exact   *matcher // literal and bytes rules
+	exactIx []int    // exact pattern index -> rule index
+	folded  *matcher // icase rules
+	foldIx  []int
+	regexes []*regexp.Regexp
+	regexIx []int
+}
+
+// compile validates rules and builds the matchers.
+func compile(rules []Rule) (*ruleset, error) {
+	rs := &ruleset{}
+	var exact, folded [][]byte
+	ids := make(map[string]bool)
+	size := 0
+
+	for i, r := range rules {
+		if r.ID == "" {
+			return nil, fmt.Errorf("rule %d: missing id", i)
+		}
+		if ids[r.ID] {
+			return nil, fmt.Errorf("rule %q: duplicate id", r.ID)
+		}
+		ids[r.ID] = true
+		if r.Pattern == "" {
+			return nil, fmt.Errorf("rule %q: empty pattern", r.ID)
+		}
+		if size += len(r.Pattern); size > maxPatternBytes {
+			return nil, fmt.Errorf("rule %q: patterns total more than %d bytes", r.ID, maxPatternBytes)
+		}
+
+		cr := compiledRule{Rule: r}
+		switch r.Action {
+		case ActionFlag, ActionReject:
+		case ActionShortenTTL:
+			ttl, err := time.ParseDuration(r.TTL)
+			if err != nil || ttl <= 0 {
+				return nil, fmt.Errorf("rule %q: shorten_ttl needs a positive ttl", r.ID)
+			}
+			if ttl > config.PasteTTL {
+				return nil, fmt.Errorf("rule %q: shorten_ttl ttl %v is longer than the default of %v", r.ID, ttl, config.PasteTTL)
+			}
+			cr.ttl = ttl
+		default:
+			return nil, fmt.Errorf("rule %q: unknown action %q", r.ID, r.Action)
+		}
+
+		idx := len(rs.rules)
+		switch r.Type {
+		case TypeLiteral:
+			exact = append(exact, []byte(r.Pattern))
+			rs.exactIx = append(rs.exactIx, idx)
+		case TypeBytes:
+			b, err := hex.DecodeString(strings.Join(strings.Fields(r.Pattern), ""))
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: invalid hex pattern: %w", r.ID, err)
+			}
+			exact = append(exact, b)
+			rs.exactIx = append(rs.exactIx, idx)
+		case TypeCaseInsensitive:
+			folded = append(folded, bytes.ToLower([]byte(r.Pattern)))
+			rs.foldIx = append(rs.foldIx, idx)
+		case TypeRegex:
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", r.ID, err)
+			}
+			rs.regexes = append(rs.regexes, re)
+			rs.regexIx = append(rs.regexIx, idx)
+		default:
+			return nil, fmt.Errorf("rule %q: unknown type %q", r.ID, r.Type)
+		}
+		rs.rules = append(rs.rules, cr)
+	}
+
+	rs.exact = newMatcher(exact, false)
+	rs.folded = newMatcher(folded, true)
+	return rs, nil
+}
+
+// scan matches body against every rule.
+func (rs *ruleset) scan(body []byte) Verdict {
+	var v Verdict
+	hit := func(idx int) {
+		r := rs.rules[idx]
+		v.Matched = append(v.Matched, Match{RuleID: r.ID, Action: r.Action})
+		if severity[r.Action] > severity[v.Action] {
+			v.Action = r.Action
+		}
+		if r.Action == ActionShortenTTL && (v.TTL == 0 || r.ttl < v.TTL) {
+			v.TTL = r.ttl
+		}
+	}
+
+	rs.exact.scan(body, func(p int) { hit(rs.exactIx[p]) })
+	rs.folded.scan(body, func(p int) { hit(rs.foldIx[p]) })
+	for i, re := range rs.regexes {
+		if re.Match(body) {
+			hit(rs.regexIx[i])
+		}
+	}
+	return v
+}
+
+// RuleIDs returns the IDs of the matched rules.
+func (v Verdict) RuleIDs() []string {
+	ids := make([]string, len(v.Matched))
+	for i, m := range v.Matched {
+		ids[i] = m.RuleID
+	}
+	return ids
+}
+
+// Engine scans pastes against a ruleset that can be replaced at runtime.
+// It is safe for concurrent use.
+type Engine struct {
+	rules atomic.Pointer[ruleset]
+}
+
+// New creates an engine with the given initial rules.
+func New(rules []Rule) (*Engine, error) {
+	e := &Engine{}
+	if err := e.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetRules atomically replaces the ruleset. On error the current rules are kept.
+func (e *Engine) SetRules(rules []Rule) error {
+	rs, err := compile(rules)
+	if err != nil {
+		return err
+	}
+	e.rules.Store(rs)
+	return nil
+}
+
+// Scan matches body against the active rules.
+func (e *Engine) Scan(body []byte) Verdict {
+	return e.rules.Load().scan(body)
+}
+
+// Parse decodes a JSON ruleset document.
+func Parse(data []byte) ([]Rule, error) {
+	var rs Ruleset
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rs); err != nil {
+		return nil, fmt.Errorf("parsing rules: %w", err)
+	}
+	if rs.Rules == nil {
+		return nil, errors.New("parsing rules: missing rules list")
+	}
+	return rs.Rules, nil
+}
@@ -0,0 +1,32 @@
+package filter
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetRulesPatternLimit(t *testing.T) {
+	e, err := New(DefaultRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Many rules that together exceed the limit are refused as one
+	var rules []Rule
+	pattern := strings.Repeat("x", 64*1024)
+	for i := 0; i*len(pattern) <= maxPatternBytes; i++ {
+		rules = append(rules, Rule{ID: "big-" + strconv.Itoa(i), Type: TypeLiteral, Pattern: pattern + strconv.Itoa(i), Action: ActionFlag})
+	}
+	if err := e.SetRules(rules); err == nil {
+		t.Fatal("SetRules() accepted patterns over the limit")
+	}
+	if v := e.Scan([]byte("Cookie: mstshash=Administrator")); v.Action != ActionReject {
+		t.Errorf("Scan() after a refused reload = %+v, want the previous rules kept", v)
+	}
+
+	// Just under it is fine
+	if err := e.SetRules(rules[:len(rules)-2]); err != nil {
+		t.Errorf("SetRules() under the limit error = %v", err)
+	}
+}
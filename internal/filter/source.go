@@ -0,0 +1,88 @@
+package filter
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/tombowditch/pastey-serv/internal/reload"
+)
+
+// Source loads a JSON ruleset document.
+type Source interface {
+	// Load returns the current document. ErrNoRules means the source has none,
+	// in which case the current rules are kept.
+	Load(ctx context.Context) ([]byte, error)
+	String() string
+}
+
+// ErrNoRules is returned by a Source that holds no ruleset.
+var ErrNoRules = errors.New("no rules in source")
+
+// FileSource reads rules from a file on disk.
+type FileSource struct {
+	Path string
+}
+
+// Load reads the file.
+func (f FileSource) Load(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(f.Path)
+}
+
+func (f FileSource) String() string {
+	return "file:" + f.Path
+}
+
+// RedisSource reads rules from a Redis string key, so every instance
+// picks up a change made with a single SET.
+type RedisSource struct {
+	Client redis.UniversalClient
+	Key    string
+}
+
+// Load reads the key.
+func (r RedisSource) Load(ctx context.Context) ([]byte, error) {
+	data, err := r.Client.Get(ctx, r.Key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNoRules
+	}
+	return data, err
+}
+
+func (r RedisSource) String() string {
+	return "redis:" + r.Key
+}
+
+// Reload loads rules from src and installs them if they changed since last.
+// It returns the document that is now active.
+func (e *Engine) Reload(ctx context.Context, src Source, last []byte) ([]byte, error) {
+	return e.loader(src).Reload(ctx, last)
+}
+
+// Watch polls src every interval and installs changed rules, until ctx is done.
+// Invalid documents are logged and the previous rules stay active.
+func (e *Engine) Watch(ctx context.Context, src Source, interval time.Duration, last []byte) {
+	e.loader(src).Watch(ctx, interval, last, func(err error) {
+		if !errors.Is(err, ErrNoRules) {
+			slog.ErrorContext(ctx, "reloading filter rules failed", "source", src.String(), "error", err)
+		}
+	})
+}
+
+func (e *Engine) loader(src Source) reload.Loader[[]Rule] {
+	return reload.Loader[[]Rule]{
+		Load:  src.Load,
+		Parse: Parse,
+		Install: func(ctx context.Context, rules []Rule) error {
+			if err := e.SetRules(rules); err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "loaded filter rules", "source", src.String(), "rules", len(rules))
+			return nil
+		},
+	}
+}
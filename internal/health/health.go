@@ -0,0 +1,107 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkTimeout bounds how long a readiness probe waits for all checks.
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable. A nil error means healthy.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker aggregates dependency checks for liveness and readiness probes.
+type Checker struct {
+	mu           sync.RWMutex
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+// New creates a Checker with no checks registered.
+func New() *Checker {
+	return &Checker{}
+}
+
+// Add registers a named readiness check.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Shutdown marks the process as shutting down so readiness fails
+// and load balancers stop routing new traffic to it.
+func (c *Checker) Shutdown() {
+	c.shuttingDown.Store(true)
+}
+
+// LiveHandler reports that the process is alive. It never checks dependencies,
+// so a Redis outage doesn't get the process restarted.
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadyHandler runs every registered check and responds 200 if all pass,
+// or 503 listing the failures. It always fails once Shutdown has been called.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+
+		if c.shuttingDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("shutting down\n"))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		defer cancel()
+
+		failures := c.run(ctx)
+		if len(failures) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(strings.Join(failures, "\n") + "\n"))
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// run executes all checks concurrently and returns a line per failure.
+func (c *Checker) run(ctx context.Context) []string {
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	results := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = nc.check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, err := range results {
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", checks[i].name, err))
+		}
+	}
+	return failures
+}
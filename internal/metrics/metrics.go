@@ -0,0 +1,124 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "pastey"
+
+var (
+	// PastesCreated counts successfully stored pastes per channel.
+	PastesCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pastes_created_total",
+		Help:      "Pastes successfully created.",
+	}, []string{"channel"})
+
+	// PasteReads counts successful paste reads per channel.
+	PasteReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "paste_reads_total",
+		Help:      "Pastes successfully read.",
+	}, []string{"channel"})
+
+	// PasteNotFound counts reads of missing or expired pastes per channel.
+	PasteNotFound = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "paste_not_found_total",
+		Help:      "Reads of pastes that don't exist or have expired.",
+	}, []string{"channel"})
+
+	// RateLimited counts requests rejected by a rate limiter.
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests rejected by rate limiting.",
+	}, []string{"limit"})
+
+	// ACLRejections counts requests rejected by the IP access control lists.
+	ACLRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "acl_rejections_total",
+		Help:      "Requests rejected by IP access control lists.",
+	}, []string{"operation"})
+
+	// ValidationRejections counts pastes rejected by validation, including blacklisted content.
+	ValidationRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "validation_rejections_total",
+		Help:      "Pastes rejected by validation.",
+	}, []string{"channel", "reason"})
+
+	// FilterMatches counts content filter rule matches.
+	FilterMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "filter_matches_total",
+		Help:      "Content filter rule matches.",
+	}, []string{"rule", "action"})
+
+	// SecretsDetected counts pastes containing each kind of detected secret.
+	SecretsDetected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secrets_detected_total",
+		Help:      "Pastes containing detected secrets, by kind and applied policy.",
+	}, []string{"kind", "policy"})
+
+	// PasteSize observes the size of created pastes.
+	PasteSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "paste_size_bytes",
+		Help:      "Size of created pastes in bytes.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 10), // 64B .. 16MB
+	}, []string{"channel"})
+
+	// StoreDuration observes the latency of store operations.
+	StoreDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "store_operation_duration_seconds",
+		Help:      "Latency of store operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// IDCollisions counts generated identifiers that were already taken.
+	IDCollisions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "id_collisions_total",
+		Help:      "Generated paste identifiers that collided with an existing paste.",
+	})
+
+	// TCPRejectedConnections counts TCP connections refused or cut off by connection limits.
+	TCPRejectedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tcp_rejected_connections_total",
+		Help:      "TCP connections refused or closed by connection limits.",
+	}, []string{"reason"})
+
+	// TCPActiveConnections tracks connections currently being handled by the TCP server.
+	TCPActiveConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tcp_active_connections",
+		Help:      "TCP connections currently open.",
+	})
+
+	// SSHRejectedConnections counts SSH connections refused by connection limits.
+	SSHRejectedConnections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ssh_rejected_connections_total",
+		Help:      "SSH connections refused by connection limits.",
+	}, []string{"reason"})
+
+	// LiveUploads tracks live pastes currently being uploaded.
+	LiveUploads = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "live_uploads",
+		Help:      "Live pastes currently being uploaded.",
+	})
+
+	// LiveViewers tracks clients currently following live pastes.
+	LiveViewers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "live_viewers",
+		Help:      "Clients currently following live pastes.",
+	})
+)
@@ -0,0 +1,110 @@
+package paste
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DirectivePrefix starts an optional first line of create options on stream
+// transports such as netcat, for example:
+//
+//	#pastey secure ttl=1h burn
+//
+// Options are "secure" (long unguessable ID), "ttl=<duration>" (shorter
+// lifetime, e.g. 30m or 6h), "burn" (delete after the first read) and "live"
+// (hand out the URL immediately and show content to viewers as it arrives).
+// Stream transports also accept "length=<bytes>" (exactly that many bytes
+// follow the line) and "eof" (wait for the client to half-close rather than
+// for it to go idle). The line is removed before the paste is stored.
+//
+// To store content whose first line genuinely starts with "#pastey", add one
+// more '#': a leading "##pastey" is stored as "#pastey", "###pastey" as
+// "##pastey", and so on.
+const DirectivePrefix = "#pastey"
+
+// Directive holds the create options given in a directive line.
+type Directive struct {
+	Secure bool
+	TTL    time.Duration
+	Burn   bool
+	// Length is the exact size of the paste following the line, or zero if unknown.
+	Length int
+	// WaitEOF means the upload ends only when the client half-closes.
+	WaitEOF bool
+	// Live streams the paste to viewers while it is uploaded; see Service.StartLive.
+	Live bool
+}
+
+// ParseDirective splits an optional directive line off the start of body.
+// It returns the options and the remaining body, which is body itself when
+// there is no directive, or body minus one '#' when the prefix is escaped.
+// Malformed directives return a *ValidationError.
+func ParseDirective(body []byte) (Directive, []byte, error) {
+	rest, ok := bytes.CutPrefix(body, []byte(DirectivePrefix))
+	if !ok {
+		if unescaped := bytes.TrimLeft(body, "#"); len(body)-len(unescaped) >= 2 && bytes.HasPrefix(unescaped, []byte("pastey")) {
+			return Directive{}, body[1:], nil
+		}
+		return Directive{}, body, nil
+	}
+	if len(rest) > 0 && rest[0] != ' ' && rest[0] != '\t' && rest[0] != '\r' && rest[0] != '\n' {
+		// Something like "#pasteybin" is content, not a directive
+		return Directive{}, body, nil
+	}
+
+	line, content, _ := bytes.Cut(rest, []byte("\n"))
+	var d Directive
+	for _, opt := range strings.Fields(string(line)) {
+		name, value, hasValue := strings.Cut(opt, "=")
+		switch {
+		case name == "secure" && !hasValue:
+			d.Secure = true
+		case name == "burn" && !hasValue:
+			d.Burn = true
+		case name == "eof" && !hasValue:
+			d.WaitEOF = true
+		case name == "live" && !hasValue:
+			d.Live = true
+		case name == "length" && hasValue:
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return Directive{}, nil, &ValidationError{
+					StatusCode: http.StatusBadRequest,
+					Message:    "invalid length " + value,
+					Reason:     ReasonBadOptions,
+				}
+			}
+			d.Length = n
+		case name == "ttl" && hasValue:
+			ttl, err := ParseTTL(value)
+			if err != nil {
+				return Directive{}, nil, err
+			}
+			d.TTL = ttl
+		default:
+			return Directive{}, nil, &ValidationError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "unknown option " + opt + "\nusage: " + DirectivePrefix + " [secure] [ttl=<duration>] [burn] [live] [length=<bytes>] [eof]",
+				Reason:     ReasonBadOptions,
+			}
+		}
+	}
+	return d, content, nil
+}
+
+// ParseTTL parses a requested paste lifetime such as "30m" or "6h".
+// Service.Create checks that it is within the allowed range.
+func ParseTTL(s string) (time.Duration, error) {
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl <= 0 {
+		return 0, &ValidationError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "invalid ttl " + s + " (use a duration such as 30m or 6h)",
+			Reason:     ReasonBadOptions,
+		}
+	}
+	return ttl, nil
+}
@@ -0,0 +1,72 @@
+package paste
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDirective(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		want     Directive
+		wantBody string
+		wantErr  bool
+	}{
+		{name: "no directive", input: "hello\n", wantBody: "hello\n"},
+		{name: "empty", input: "", wantBody: ""},
+		{name: "bare prefix", input: "#pastey\nbody", wantBody: "body"},
+		{name: "prefix only, no newline", input: "#pastey", wantBody: ""},
+		{name: "options", input: "#pastey secure burn ttl=30m\nbody\n", want: Directive{Secure: true, Burn: true, TTL: 30 * time.Minute}, wantBody: "body\n"},
+		{name: "stream options", input: "#pastey live eof length=5\nhello", want: Directive{Live: true, WaitEOF: true, Length: 5}, wantBody: "hello"},
+		{name: "crlf", input: "#pastey secure\r\nbody", want: Directive{Secure: true}, wantBody: "body"},
+		{name: "tabs and repeated spaces", input: "#pastey\tsecure   burn\nx", want: Directive{Secure: true, Burn: true}, wantBody: "x"},
+		{name: "truncated line", input: "#pastey secure", want: Directive{Secure: true}, wantBody: ""},
+		{name: "only the first line", input: "#pastey\n#pastey burn\n", wantBody: "#pastey burn\n"},
+		{name: "prefix inside a word", input: "#pasteybin\n", wantBody: "#pasteybin\n"},
+		{name: "not at the start", input: " #pastey burn\n", wantBody: " #pastey burn\n"},
+		{name: "case sensitive", input: "#Pastey burn\n", wantBody: "#Pastey burn\n"},
+		{name: "escaped once", input: "##pastey burn\nx", wantBody: "#pastey burn\nx"},
+		{name: "escaped twice", input: "###pastey\n", wantBody: "##pastey\n"},
+		{name: "escaped word", input: "##pasteybin", wantBody: "#pasteybin"},
+		{name: "escaped truncated", input: "##paste", wantBody: "##paste"},
+		{name: "hashes only", input: "####", wantBody: "####"},
+		{name: "markdown heading", input: "## pastey\n", wantBody: "## pastey\n"},
+		{name: "unknown option", input: "#pastey bogus\n", wantErr: true},
+		{name: "value on flag", input: "#pastey burn=yes\n", wantErr: true},
+		{name: "missing value", input: "#pastey ttl\n", wantErr: true},
+		{name: "empty value", input: "#pastey ttl=\n", wantErr: true},
+		{name: "invalid ttl", input: "#pastey ttl=soon\n", wantErr: true},
+		{name: "negative ttl", input: "#pastey ttl=-1h\n", wantErr: true},
+		{name: "zero length", input: "#pastey length=0\n", wantErr: true},
+		{name: "negative length", input: "#pastey length=-5\n", wantErr: true},
+		{name: "non-numeric length", input: "#pastey length=5k\n", wantErr: true},
+		{name: "oversized length", input: "#pastey length=" + strings.Repeat("9", 40) + "\n", wantErr: true},
+		{name: "oversized line", input: "#pastey " + strings.Repeat("secure ", 10000) + "x\n", wantErr: true},
+		{name: "option on second line", input: "#pastey\nbogus\n", wantBody: "bogus\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, body, err := ParseDirective([]byte(tt.input))
+			if tt.wantErr {
+				var ve *ValidationError
+				if !errors.As(err, &ve) {
+					t.Fatalf("ParseDirective(%q) error = %v, want a *ValidationError", tt.input, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDirective(%q) error = %v", tt.input, err)
+			}
+			if d != tt.want {
+				t.Errorf("ParseDirective(%q) = %+v, want %+v", tt.input, d, tt.want)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("ParseDirective(%q) body = %q, want %q", tt.input, body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package paste
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxGrepLength bounds grep patterns. Go regexps run in linear time, but a
+// huge pattern is still expensive to compile.
+const maxGrepLength = 256
+
+// LineFilter selects part of a paste by line, for linking to a section of a
+// long log. Each step applies to the lines left by the previous one: first the
+// From-To range, then Grep, then Head, then Tail. Zero values select
+// everything.
+type LineFilter struct {
+	// From and To are the first and last line numbers to keep, counting from 1.
+	From, To int
+	// Grep keeps only lines matching the pattern.
+	Grep *regexp.Regexp
+	// Head keeps only the first Head lines.
+	Head int
+	// Tail keeps only the last Tail lines.
+	Tail int
+}
+
+// ParseLineFilter reads a LineFilter from the query parameters lines (such as
+// "120-180", "120-" or "120"), grep, head and tail.
+func ParseLineFilter(query url.Values) (LineFilter, error) {
+	var f LineFilter
+	if v := query.Get("lines"); v != "" {
+		from, to, ranged := strings.Cut(v, "-")
+		var err error
+		if f.From, err = strconv.Atoi(from); err != nil || f.From < 1 {
+			return LineFilter{}, badFilter("invalid lines " + v + " (use a range such as 120-180)")
+		}
+		switch {
+		case !ranged:
+			f.To = f.From
+		case to != "":
+			if f.To, err = strconv.Atoi(to); err != nil || f.To < f.From {
+				return LineFilter{}, badFilter("invalid lines " + v + " (use a range such as 120-180)")
+			}
+		}
+	}
+	if v := query.Get("grep"); v != "" {
+		if len(v) > maxGrepLength {
+			return LineFilter{}, badFilter("grep pattern longer than " + strconv.Itoa(maxGrepLength) + " characters")
+		}
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return LineFilter{}, badFilter("invalid grep pattern: " + err.Error())
+		}
+		f.Grep = re
+	}
+	var err error
+	if f.Head, err = parseCount(query, "head"); err != nil {
+		return LineFilter{}, err
+	}
+	if f.Tail, err = parseCount(query, "tail"); err != nil {
+		return LineFilter{}, err
+	}
+	return f, nil
+}
+
+// parseCount reads an optional positive number of lines.
+func parseCount(query url.Values, name string) (int, error) {
+	v := query.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, badFilter("invalid " + name + " " + v + " (use a number of lines)")
+	}
+	return n, nil
+}
+
+func badFilter(message string) error {
+	return &ValidationError{StatusCode: http.StatusBadRequest, Message: message, Reason: ReasonBadOptions}
+}
+
+// Active reports whether f selects less than the whole paste.
+func (f LineFilter) Active() bool {
+	return f.From > 0 || f.To > 0 || f.Grep != nil || f.Head > 0 || f.Tail > 0
+}
+
+// Apply writes the lines of body that f selects to w. Lines are written as
+// they are found, except for Tail which holds back the last Tail lines.
+func (f LineFilter) Apply(w io.Writer, body string) error {
+	var tail []string
+	selected := 0
+	for n, rest := 1, body; rest != ""; n++ {
+		line := rest
+		if i := strings.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i+1], rest[i+1:]
+		} else {
+			rest = ""
+		}
+
+		if n < f.From {
+			continue
+		}
+		if f.To > 0 && n > f.To {
+			break
+		}
+		if f.Grep != nil && !f.Grep.MatchString(strings.TrimSuffix(line, "\n")) {
+			continue
+		}
+		if f.Head > 0 && selected == f.Head {
+			break
+		}
+		selected++
+
+		if f.Tail > 0 {
+			if len(tail) == f.Tail {
+				tail = tail[1:]
+			}
+			tail = append(tail, line)
+			continue
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+
+	for _, line := range tail {
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
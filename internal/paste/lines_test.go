@@ -0,0 +1,107 @@
+package paste
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLineFilter(t *testing.T) {
+	// "line 1\n" to "line 10\n"
+	var b strings.Builder
+	for i := 1; i <= 10; i++ {
+		b.WriteString("line " + strconv.Itoa(i) + "\n")
+	}
+	body := b.String()
+
+	tests := []struct {
+		name  string
+		query string
+		// body replaces the ten numbered lines if set.
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{name: "none", query: "", want: body},
+		{name: "single line", query: "lines=3", want: "line 3\n"},
+		{name: "range", query: "lines=3-5", want: "line 3\nline 4\nline 5\n"},
+		{name: "open range", query: "lines=9-", want: "line 9\nline 10\n"},
+		{name: "range past end", query: "lines=9-500", want: "line 9\nline 10\n"},
+		{name: "start past end", query: "lines=11", want: ""},
+		{name: "grep", query: "grep=1", want: "line 1\nline 10\n"},
+		{name: "grep anchored", query: "grep=" + url.QueryEscape("^line [2-4]$"), want: "line 2\nline 3\nline 4\n"},
+		{name: "grep no match", query: "grep=nothing", want: ""},
+		{name: "head", query: "head=2", want: "line 1\nline 2\n"},
+		{name: "head past end", query: "head=100", want: body},
+		{name: "tail", query: "tail=2", want: "line 9\nline 10\n"},
+		{name: "tail past end", query: "tail=100", want: body},
+		{name: "range then grep", query: "lines=2-10&grep=1", want: "line 10\n"},
+		{name: "grep then head", query: "grep=line&head=1", want: "line 1\n"},
+		{name: "head then tail", query: "head=5&tail=2", want: "line 4\nline 5\n"},
+		{name: "all steps", query: "lines=2-9&grep=[02468]&head=3&tail=2", want: "line 4\nline 6\n"},
+		{name: "no final newline", query: "tail=1", body: "a\nb", want: "b"},
+		{name: "crlf kept", query: "lines=2", body: "a\r\nb\r\nc", want: "b\r\n"},
+		{name: "empty lines", query: "lines=2-3", body: "a\n\n\nd\n", want: "\n\n"},
+		{name: "invalid utf-8", query: "grep=b", body: "a\xff\nb\xfe\n", want: "b\xfe\n"},
+		{name: "zero line", query: "lines=0", wantErr: true},
+		{name: "negative line", query: "lines=-3", wantErr: true},
+		{name: "reversed range", query: "lines=5-3", wantErr: true},
+		{name: "range without start", query: "lines=-5", wantErr: true},
+		{name: "non-numeric line", query: "lines=abc", wantErr: true},
+		{name: "non-numeric end", query: "lines=1-x", wantErr: true},
+		{name: "double range", query: "lines=1-2-3", wantErr: true},
+		{name: "oversized line number", query: "lines=" + strings.Repeat("9", 30), wantErr: true},
+		{name: "invalid grep", query: "grep=" + url.QueryEscape("(unclosed"), wantErr: true},
+		{name: "oversized grep", query: "grep=" + strings.Repeat("a", maxGrepLength+1), wantErr: true},
+		{name: "longest grep", query: "grep=" + strings.Repeat("a", maxGrepLength), want: ""},
+		{name: "zero head", query: "head=0", wantErr: true},
+		{name: "negative tail", query: "tail=-1", wantErr: true},
+		{name: "fractional head", query: "head=1.5", wantErr: true},
+		{name: "oversized tail", query: "tail=" + strings.Repeat("9", 30), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := ParseLineFilter(query)
+			if tt.wantErr {
+				var ve *ValidationError
+				if !errors.As(err, &ve) {
+					t.Fatalf("ParseLineFilter(%q) error = %v, want a *ValidationError", tt.query, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLineFilter(%q) error = %v", tt.query, err)
+			}
+			if active := tt.query != ""; f.Active() != active {
+				t.Errorf("Active() = %v, want %v", f.Active(), active)
+			}
+
+			in := body
+			if tt.body != "" {
+				in = tt.body
+			}
+			var out strings.Builder
+			if err := f.Apply(&out, in); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("Apply(%q) = %q, want %q", tt.query, out.String(), tt.want)
+			}
+		})
+	}
+
+	t.Run("empty body", func(t *testing.T) {
+		f := LineFilter{From: 1, Tail: 3}
+		var out strings.Builder
+		if err := f.Apply(&out, ""); err != nil || out.String() != "" {
+			t.Errorf("Apply() = %q, %v, want nothing", out.String(), err)
+		}
+	})
+}
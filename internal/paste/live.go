@@ -0,0 +1,326 @@
+package paste
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tombowditch/pastey-serv/internal/config"
+	"github.com/tombowditch/pastey-serv/internal/filter"
+	"github.com/tombowditch/pastey-serv/internal/metrics"
+	"github.com/tombowditch/pastey-serv/internal/secrets"
+	"github.com/tombowditch/pastey-serv/internal/store"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
+	"github.com/tombowditch/pastey-serv/internal/util/randutil"
+)
+
+const (
+	// livePollInterval is how often followers check a live paste for new content.
+	livePollInterval = 500 * time.Millisecond
+	// liveFinishMargin keeps a live paste's reservation beyond its upload
+	// deadline, leaving time to store it once the upload is cut off.
+	liveFinishMargin = time.Minute
+	// liveScanOverlap bounds how much of a live paste's latest line is held
+	// back from viewers, so filter and secret matches that span two appends
+	// are caught before any of them is shown.
+	liveScanOverlap = 1024
+)
+
+// ErrLive is returned by Get for a live paste that is still being uploaded.
+// Transports stream it to the client with Follow.
+var ErrLive = errors.New("paste is still being uploaded")
+
+var (
+	errLiveUnavailable = &ValidationError{
+		StatusCode: http.StatusBadRequest,
+		Message:    "live pastes are not available",
+		Reason:     ReasonBadOptions,
+	}
+	errLiveBurn = &ValidationError{
+		StatusCode: http.StatusBadRequest,
+		Message:    "live pastes can't be burn-after-reading",
+		Reason:     ReasonBadOptions,
+	}
+)
+
+// LiveUpload is a paste whose content is shown to viewers while it is being
+// uploaded. Each append is screened by the content filter and, when secrets
+// are rejected or redacted, the secret policy before viewers see it, and the
+// whole paste is validated again when Finish stores it as a normal paste.
+// Under SecretsRestrict content can't be screened without delaying it until
+// the end, so live pastes always get a secure ID instead.
+type LiveUpload struct {
+	ID  string
+	URL string
+	// Expires is when the upload must be finished by.
+	Expires time.Time
+
+	s        *Service
+	streamer store.Streamer
+	req      CreateRequest
+	size     int
+	// held is screened content not yet shown to viewers.
+	held []byte
+	// secrets lists the kinds of secrets redacted from published content.
+	secrets []string
+	end     sync.Once
+}
+
+// StartLive checks the requested options and reserves an identifier for a
+// live paste, whose URL can be handed out before any content arrives.
+// req.Body is ignored. Errors are like Create's.
+func (s *Service) StartLive(ctx context.Context, req CreateRequest) (u *LiveUpload, err error) {
+	ctx, span := tracing.Start(ctx, "paste.StartLive", trace.WithAttributes(
+		attribute.String("paste.channel", string(req.Channel)),
+		attribute.Bool("paste.secure", req.Secure),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	streamer, ok := s.store.(store.Streamer)
+	if !ok || s.opts.LiveMaxDuration <= 0 {
+		err = errLiveUnavailable
+	} else if req.Burn {
+		err = errLiveBurn
+	} else {
+		err = validateTTL(req.TTL)
+	}
+	if err != nil {
+		metrics.ValidationRejections.WithLabelValues(string(req.Channel), ReasonBadOptions).Inc()
+		return nil, err
+	}
+
+	idLength := IDLength(req.Secure || s.opts.SecretPolicy == SecretsRestrict)
+	for tried := 0; tried < config.IDRetries; tried++ {
+		identifier := randutil.RandString(idLength)
+		ok, err := streamer.StartLive(ctx, identifier, s.opts.LiveMaxDuration+liveFinishMargin)
+		if err != nil {
+			slog.ErrorContext(ctx, "store start live failed", "error", err, "channel", req.Channel)
+			return nil, errors.Join(ErrStore, err)
+		}
+		if ok {
+			slog.InfoContext(ctx, "started live paste", "identifier", identifier, "channel", req.Channel, "remote", req.ClientIP)
+			metrics.LiveUploads.Inc()
+			return &LiveUpload{
+				ID:       identifier,
+				URL:      config.BaseURL + identifier,
+				Expires:  time.Now().Add(s.opts.LiveMaxDuration),
+				s:        s,
+				streamer: streamer,
+				req:      req,
+			}, nil
+		}
+		metrics.IDCollisions.Inc()
+		span.AddEvent("identifier collision")
+	}
+
+	slog.ErrorContext(ctx, "could not generate unique identifier after retries", "channel", req.Channel)
+	return nil, ErrNoIdentifier
+}
+
+// Append publishes more content to viewers once it has been screened. Errors
+// are a *ValidationError if the paste grows too large or is refused by the
+// content filter or secret policy, ErrNotFound if it was removed by a
+// moderator or expired, or wrap ErrStore; the caller should then Abort.
+func (u *LiveUpload) Append(ctx context.Context, data []byte) error {
+	u.size += len(data)
+	if u.size > config.MaxPayloadSize {
+		metrics.ValidationRejections.WithLabelValues(string(u.req.Channel), ReasonTooLarge).Inc()
+		return errTooLarge
+	}
+
+	return u.publish(ctx, data, false)
+}
+
+// publish screens data and appends what may be shown to the live paste. The
+// final call publishes everything still held back.
+func (u *LiveUpload) publish(ctx context.Context, data []byte, final bool) error {
+	publish, err := u.screen(ctx, data, final)
+	if err != nil || len(publish) == 0 {
+		return err
+	}
+	if err := u.streamer.AppendLive(ctx, u.ID, publish); err != nil {
+		return u.storeError(ctx, "append live", err)
+	}
+	return nil
+}
+
+// screen scans data with the content held back from earlier appends and
+// returns what may be published, redacting secrets if the policy says so.
+// Unless final, a line that may continue in the next append is held back, up
+// to its last liveScanOverlap bytes, and so is any secret that may continue,
+// such as a private key without its end.
+func (u *LiveUpload) screen(ctx context.Context, data []byte, final bool) ([]byte, error) {
+	s := u.s
+	pending := append(u.held, data...)
+
+	verdict := s.filter.Scan(pending)
+	if verdict.Action == filter.ActionReject {
+		for _, m := range verdict.Matched {
+			metrics.FilterMatches.WithLabelValues(m.RuleID, m.Action).Inc()
+		}
+		metrics.ValidationRejections.WithLabelValues(string(u.req.Channel), ReasonBlacklisted).Inc()
+		slog.WarnContext(ctx, "live paste rejected by filter", "identifier", u.ID, "rules", verdict.RuleIDs(), "channel", u.req.Channel, "remote", u.req.ClientIP)
+		return nil, errBlacklisted
+	}
+
+	cut := len(pending)
+	if !final {
+		cut = max(bytes.LastIndexByte(pending, '\n')+1, len(pending)-liveScanOverlap)
+	}
+	var findings []secrets.Finding
+	if s.opts.SecretPolicy == SecretsReject || s.opts.SecretPolicy == SecretsRedact {
+		findings = s.opts.Secrets.Scan(pending)
+	}
+	if len(findings) > 0 && s.opts.SecretPolicy == SecretsReject {
+		return nil, secretsRejected(u.req, s.secretsDetected(ctx, u.req, findings))
+	}
+	// Findings are ordered and don't overlap, so those before one reaching
+	// past the cut end before it
+	for i, f := range findings {
+		if f.End > cut {
+			cut = min(cut, f.Start)
+			findings = findings[:i]
+			break
+		}
+	}
+
+	publish := pending[:cut]
+	if len(findings) > 0 {
+		for _, kind := range s.secretsDetected(ctx, u.req, findings) {
+			if !slices.Contains(u.secrets, kind) {
+				u.secrets = append(u.secrets, kind)
+			}
+		}
+		publish = secrets.Redact(publish, findings)
+	}
+	u.held = append([]byte(nil), pending[cut:]...)
+	return publish, nil
+}
+
+// Finish validates the uploaded content like Create and stores it as a normal
+// paste under the live paste's ID. Content that fails validation is discarded.
+func (u *LiveUpload) Finish(ctx context.Context) (res CreateResult, err error) {
+	ctx, span := tracing.Start(ctx, "paste.FinishLive", trace.WithAttributes(
+		attribute.String("paste.channel", string(u.req.Channel)),
+		attribute.String("paste.id", u.ID),
+		attribute.Int("paste.size", u.size),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	// Viewers see the end of the paste before it ends
+	if err := u.publish(ctx, nil, true); err != nil {
+		u.Abort(ctx)
+		return CreateResult{}, err
+	}
+	req := u.req
+	if req.Body, _, err = u.streamer.ReadLive(ctx, u.ID, 0); err != nil {
+		u.Abort(ctx)
+		return CreateResult{}, u.storeError(ctx, "read live", err)
+	}
+	body, meta, res, err := u.s.prepare(ctx, req, config.MaxPayloadSize)
+	if err != nil {
+		u.Abort(ctx)
+		return CreateResult{}, err
+	}
+	if len(u.secrets) > 0 {
+		for _, kind := range res.Secrets {
+			if !slices.Contains(u.secrets, kind) {
+				u.secrets = append(u.secrets, kind)
+			}
+		}
+		res.Secrets = u.secrets
+		res.SecretPolicy = u.s.opts.SecretPolicy
+	}
+	meta.CreatedAt = time.Now()
+	if err := u.streamer.FinishLive(ctx, u.ID, body, meta); err != nil {
+		u.Abort(ctx)
+		return CreateResult{}, u.storeError(ctx, "finish live", err)
+	}
+	u.end.Do(metrics.LiveUploads.Dec)
+	return u.s.created(ctx, req, u.ID, meta, res), nil
+}
+
+// Abort discards a live paste whose upload failed, ending its viewers' streams.
+func (u *LiveUpload) Abort(ctx context.Context) {
+	u.end.Do(func() {
+		metrics.LiveUploads.Dec()
+		// Clean up even when the upload failed because the client went away
+		if err := u.streamer.AbortLive(context.WithoutCancel(ctx), u.ID); err != nil {
+			slog.ErrorContext(ctx, "store abort live failed", "error", err, "identifier", u.ID)
+		}
+	})
+}
+
+// storeError converts a store error from operation on the live paste,
+// reporting a paste that was removed or expired as ErrNotFound.
+func (u *LiveUpload) storeError(ctx context.Context, operation string, err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrNotFound
+	}
+	slog.ErrorContext(ctx, "store "+operation+" failed", "error", err, "identifier", u.ID)
+	return errors.Join(ErrStore, err)
+}
+
+// Follow calls fn with a live paste's content as it arrives, from the start,
+// until the upload ends, fn fails or ctx is done. Callers check the client
+// may read the paste with Get first, which returns ErrLive for such pastes.
+func (s *Service) Follow(ctx context.Context, id string, fn func([]byte) error) error {
+	streamer, ok := s.store.(store.Streamer)
+	if !ok {
+		return ErrNotFound
+	}
+	metrics.LiveViewers.Inc()
+	defer metrics.LiveViewers.Dec()
+
+	t := time.NewTicker(livePollInterval)
+	defer t.Stop()
+
+	offset := 0
+	for {
+		data, ended, err := streamer.ReadLive(ctx, id, offset)
+		if errors.Is(err, store.ErrNotFound) {
+			// Removed, or ended long enough ago that its content expired
+			return nil
+		}
+		if err != nil {
+			return errors.Join(ErrStore, err)
+		}
+		if len(data) > 0 {
+			if err := fn(data); err != nil {
+				return err
+			}
+			offset += len(data)
+		}
+		if ended {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// live reports whether id is a live paste still being uploaded. Store errors
+// are logged and reported as false.
+func (s *Service) live(ctx context.Context, id string) bool {
+	streamer, ok := s.store.(store.Streamer)
+	if !ok {
+		return false
+	}
+	live, err := streamer.Live(ctx, id)
+	if err != nil && ctx.Err() == nil {
+		slog.ErrorContext(ctx, "store live check failed", "error", err, "identifier", id)
+	}
+	return live
+}
@@ -2,51 +2,82 @@ package paste
 
 import (
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/tombowditch/pastey-serv/internal/config"
 )
 
+// Validation failure reasons, used to label metrics.
+const (
+	ReasonEmpty       = "empty"
+	ReasonTooLarge    = "too_large"
+	ReasonBlacklisted = "blacklisted"
+	ReasonSecrets     = "secrets"
+	ReasonBadOptions  = "bad_options"
+)
+
 // ValidationError holds validation failure details.
 type ValidationError struct {
 	StatusCode int
 	Message    string
+	Reason     string
 }
 
 func (e *ValidationError) Error() string {
 	return e.Message
 }
 
-// Validate checks if the paste body is acceptable.
+// errBlacklisted is returned when a content filter rule rejects a paste.
+var errBlacklisted = &ValidationError{
+	StatusCode: http.StatusForbidden,
+	Message:    "blacklisted phrases, antispam system\ncontact admin@ig.lc if this is in error",
+	Reason:     ReasonBlacklisted,
+}
+
+// errTooLarge is returned for pastes over config.MaxPayloadSize.
+var errTooLarge = &ValidationError{
+	StatusCode: http.StatusRequestEntityTooLarge,
+	Message:    "payload too big",
+	Reason:     ReasonTooLarge,
+}
+
+// Validate checks if the paste body is an acceptable size.
+// Content rules are applied separately by Service using a filter.Engine.
 // Returns nil if valid, or a *ValidationError with appropriate status code and message.
 func Validate(body []byte) error {
+	return validateSize(body, config.MaxPayloadSize)
+}
+
+// validateSize is Validate with a different maximum size, for resumable uploads.
+func validateSize(body []byte, maxSize int) error {
 	if len(body) == 0 {
 		return &ValidationError{
 			StatusCode: http.StatusBadRequest,
 			Message:    "empty body",
+			Reason:     ReasonEmpty,
 		}
 	}
 
-	if len(body) > config.MaxPayloadSize {
-		return &ValidationError{
-			StatusCode: http.StatusRequestEntityTooLarge,
-			Message:    "payload too big",
-		}
-	}
-
-	content := string(body)
-	for _, phrase := range config.BlacklistedPhrases {
-		if strings.Contains(content, phrase) {
-			return &ValidationError{
-				StatusCode: http.StatusForbidden,
-				Message:    "blacklisted phrases, antispam system\ncontact admin@ig.lc if this is in error",
-			}
-		}
+	if len(body) > maxSize {
+		return errTooLarge
 	}
 
 	return nil
 }
 
+// validateTTL checks that a requested paste lifetime is within the allowed range.
+// Zero means the default lifetime.
+func validateTTL(ttl time.Duration) error {
+	if ttl == 0 || (ttl >= config.MinPasteTTL && ttl <= config.PasteTTL) {
+		return nil
+	}
+	return &ValidationError{
+		StatusCode: http.StatusBadRequest,
+		Message:    "ttl must be between " + config.MinPasteTTL.String() + " and " + config.PasteTTL.String(),
+		Reason:     ReasonBadOptions,
+	}
+}
+
 // IDLength returns the appropriate ID length based on whether secure mode is requested.
 func IDLength(secure bool) int {
 	if secure {
@@ -0,0 +1,458 @@
+package paste
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tombowditch/pastey-serv/internal/acl"
+	"github.com/tombowditch/pastey-serv/internal/config"
+	"github.com/tombowditch/pastey-serv/internal/filter"
+	"github.com/tombowditch/pastey-serv/internal/metrics"
+	"github.com/tombowditch/pastey-serv/internal/ratelimit"
+	"github.com/tombowditch/pastey-serv/internal/secrets"
+	"github.com/tombowditch/pastey-serv/internal/store"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
+	"github.com/tombowditch/pastey-serv/internal/util/randutil"
+)
+
+// Channel identifies the transport a request arrived on.
+type Channel string
+
+const (
+	ChannelTCP  Channel = "tcp"
+	ChannelHTTP Channel = "http"
+	ChannelSSH  Channel = "ssh"
+)
+
+var (
+	// ErrRateLimited is returned when a client has exceeded the create rate limit.
+	ErrRateLimited = errors.New("rate limit exceeded (1 paste per 5 seconds)")
+	// ErrReadRateLimited is returned when a client has exceeded the read rate limit.
+	ErrReadRateLimited = errors.New("rate limit exceeded (1 request per second)")
+	// ErrNotFound is returned when a paste doesn't exist or has expired.
+	ErrNotFound = errors.New("not found or expired")
+	// ErrBanned is returned when a client has been banned by a moderator.
+	ErrBanned = errors.New("banned\ncontact admin@ig.lc if this is in error")
+	// ErrDenied is returned when a client's address is excluded by the access control lists.
+	ErrDenied = errors.New("access denied")
+	// ErrNoIdentifier is returned when no unused identifier could be generated.
+	ErrNoIdentifier = errors.New("could not generate identifier")
+	// ErrStore is returned (wrapped) when the backing store fails.
+	ErrStore = errors.New("store error")
+)
+
+// SecretPolicy decides what happens to pastes containing detected secrets.
+type SecretPolicy string
+
+const (
+	// SecretsOff stores pastes without scanning for secrets.
+	SecretsOff SecretPolicy = "off"
+	// SecretsReject refuses pastes containing secrets.
+	SecretsReject SecretPolicy = "reject"
+	// SecretsRedact replaces detected secrets with markers before storing.
+	SecretsRedact SecretPolicy = "redact"
+	// SecretsRestrict stores pastes unchanged but with a secure ID and short TTL.
+	SecretsRestrict SecretPolicy = "restrict"
+)
+
+// Options configures optional Service behaviour.
+type Options struct {
+	// ACL restricts which client addresses may create and read pastes.
+	// A nil ACL allows everyone.
+	ACL *acl.List
+	// Secrets scans pastes for credentials according to SecretPolicy.
+	Secrets      *secrets.Scanner
+	SecretPolicy SecretPolicy
+	// SecretTTL is the maximum lifetime of pastes restricted by SecretsRestrict.
+	SecretTTL time.Duration
+	// LiveMaxDuration bounds how long a live paste may be uploaded for.
+	// Zero disables live pastes.
+	LiveMaxDuration time.Duration
+	// UploadMaxSize is the size limit for pastes created by resumable uploads.
+	// Zero disables resumable uploads.
+	UploadMaxSize int
+}
+
+// CreateRequest describes a paste to be created.
+type CreateRequest struct {
+	Body   []byte
+	Secure bool
+	// TTL requests a lifetime shorter than the default; zero means the default.
+	TTL time.Duration
+	// Burn deletes the paste when it is first read.
+	Burn     bool
+	ClientIP string
+	Channel  Channel
+	// Owner identifies the authenticated uploader on transports that have one.
+	Owner string
+}
+
+// GetRequest describes a paste to be read.
+type GetRequest struct {
+	ID       string
+	ClientIP string
+	Channel  Channel
+}
+
+// CreateResult describes a successfully created paste.
+type CreateResult struct {
+	ID  string
+	URL string
+	// Secrets lists the kinds of secrets detected, if any.
+	Secrets []string
+	// SecretPolicy is the policy applied because secrets were detected.
+	SecretPolicy SecretPolicy
+	// TTL is the paste's lifetime when shorter than the default.
+	TTL time.Duration
+}
+
+// Notice returns a message for the uploader about secrets found in the paste, or "".
+func (r CreateResult) Notice() string {
+	if len(r.Secrets) == 0 {
+		return ""
+	}
+	kinds := strings.Join(r.Secrets, ", ")
+	switch r.SecretPolicy {
+	case SecretsRedact:
+		return "warning: redacted possible secrets (" + kinds + ")"
+	case SecretsRestrict:
+		return "warning: possible secrets (" + kinds + "), paste expires in " + r.TTL.String()
+	default:
+		return ""
+	}
+}
+
+// Service implements paste operations shared by every transport.
+type Service struct {
+	store       store.Store
+	limiter     ratelimit.Limiter
+	readLimiter ratelimit.Limiter
+	filter      *filter.Engine
+	opts        Options
+}
+
+// NewService creates a paste service backed by the given store, create and
+// read rate limiters and content filter.
+func NewService(s store.Store, createLimiter, readLimiter ratelimit.Limiter, f *filter.Engine, opts Options) *Service {
+	if opts.Secrets == nil {
+		opts.SecretPolicy = SecretsOff
+	}
+	if opts.ACL == nil {
+		opts.ACL = &acl.List{}
+	}
+	return &Service{
+		store:       s,
+		limiter:     createLimiter,
+		readLimiter: readLimiter,
+		filter:      f,
+		opts:        opts,
+	}
+}
+
+// AllowCreate checks whether a client is denied by the ACL, banned or over the
+// create rate limit. Transports call this before reading the body so rejected
+// clients can't upload.
+func (s *Service) AllowCreate(ctx context.Context, clientIP string) error {
+	if err := s.checkACL(ctx, acl.OpCreate, clientIP); err != nil {
+		return err
+	}
+	if bl, ok := s.store.(store.Banlist); ok {
+		ban, err := bl.Banned(ctx, clientIP)
+		if err != nil {
+			// Fail open like the rate limiter so a store hiccup doesn't block uploads
+			slog.ErrorContext(ctx, "ban check failed", "error", err, "remote", clientIP)
+		} else if ban != nil {
+			return ErrBanned
+		}
+	}
+	if !s.limiter.Allow(ctx, clientIP) {
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// AllowConnect checks whether the ACL lets a client read or create pastes,
+// so stream transports can turn away other clients before reading their
+// request. The operation is checked again once the request is known.
+func (s *Service) AllowConnect(ctx context.Context, clientIP string) error {
+	if s.opts.ACL.Allowed(acl.OpRead, clientIP) || s.opts.ACL.Allowed(acl.OpCreate, clientIP) {
+		return nil
+	}
+	metrics.ACLRejections.WithLabelValues("connect").Inc()
+	slog.InfoContext(ctx, "denied by access control list", "operation", "connect", "remote", clientIP)
+	return ErrDenied
+}
+
+// AllowRead checks whether a client is denied reading pastes by the ACL or
+// over the read rate limit.
+func (s *Service) AllowRead(ctx context.Context, clientIP string) error {
+	if err := s.checkACL(ctx, acl.OpRead, clientIP); err != nil {
+		return err
+	}
+	if !s.readLimiter.Allow(ctx, clientIP) {
+		return ErrReadRateLimited
+	}
+	return nil
+}
+
+// Get checks the client may read and returns the paste. Errors are
+// ErrDenied, ErrReadRateLimited, ErrNotFound, a *store.GoneError, or ErrLive
+// for a live paste to be read with Follow. Store failures are logged and
+// reported as ErrNotFound.
+func (s *Service) Get(ctx context.Context, req GetRequest) (p store.Paste, err error) {
+	ctx, span := tracing.Start(ctx, "paste.Get", trace.WithAttributes(
+		attribute.String("paste.channel", string(req.Channel)),
+		attribute.String("paste.id", req.ID),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	if err := s.AllowRead(ctx, req.ClientIP); err != nil {
+		return store.Paste{}, err
+	}
+
+	p, err = s.store.Get(ctx, req.ID)
+	var gone *store.GoneError
+	switch {
+	case err == nil:
+		metrics.PasteReads.WithLabelValues(string(req.Channel)).Inc()
+		return p, nil
+	case errors.As(err, &gone):
+		return store.Paste{}, err
+	case errors.Is(err, store.ErrNotFound):
+		if s.live(ctx, req.ID) {
+			metrics.PasteReads.WithLabelValues(string(req.Channel)).Inc()
+			return store.Paste{}, ErrLive
+		}
+		metrics.PasteNotFound.WithLabelValues(string(req.Channel)).Inc()
+	case ctx.Err() == nil:
+		slog.ErrorContext(ctx, "store get failed", "error", err, "identifier", req.ID, "channel", req.Channel)
+	}
+	return store.Paste{}, ErrNotFound
+}
+
+func (s *Service) checkACL(ctx context.Context, op acl.Operation, clientIP string) error {
+	if s.opts.ACL.Allowed(op, clientIP) {
+		return nil
+	}
+	metrics.ACLRejections.WithLabelValues(string(op)).Inc()
+	slog.InfoContext(ctx, "denied by access control list", "operation", op, "remote", clientIP)
+	return ErrDenied
+}
+
+// Create validates the paste, generates a unique identifier and stores it.
+// Errors are either a *ValidationError, ErrNoIdentifier or wrap ErrStore.
+func (s *Service) Create(ctx context.Context, req CreateRequest) (CreateResult, error) {
+	return s.create(ctx, req, config.MaxPayloadSize)
+}
+
+// create is Create for pastes of up to maxSize bytes.
+func (s *Service) create(ctx context.Context, req CreateRequest, maxSize int) (res CreateResult, err error) {
+	ctx, span := tracing.Start(ctx, "paste.Create", trace.WithAttributes(
+		attribute.String("paste.channel", string(req.Channel)),
+		attribute.Int("paste.size", len(req.Body)),
+		attribute.Bool("paste.secure", req.Secure),
+		attribute.Bool("paste.burn", req.Burn),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	body, meta, res, err := s.prepare(ctx, req, maxSize)
+	if err != nil {
+		return CreateResult{}, err
+	}
+
+	idLength := IDLength(req.Secure || res.SecretPolicy == SecretsRestrict)
+
+	// Generate unique identifier and store atomically
+	for tried := 0; tried < config.IDRetries; tried++ {
+		identifier := randutil.RandString(idLength)
+		meta.CreatedAt = time.Now()
+		ok, err := s.store.Create(ctx, identifier, body, meta)
+		if err != nil {
+			slog.ErrorContext(ctx, "store create failed", "error", err, "channel", req.Channel)
+			return CreateResult{}, errors.Join(ErrStore, err)
+		}
+		if ok {
+			return s.created(ctx, req, identifier, meta, res), nil
+		}
+		// Collision, try again
+		metrics.IDCollisions.Inc()
+		span.AddEvent("identifier collision")
+		slog.DebugContext(ctx, "identifier collision, retrying", "identifier", identifier)
+	}
+
+	slog.ErrorContext(ctx, "could not generate unique identifier after retries", "channel", req.Channel)
+	return CreateResult{}, ErrNoIdentifier
+}
+
+// prepare validates a paste of up to maxSize bytes and applies the secret
+// policy, returning the content and metadata to store.
+func (s *Service) prepare(ctx context.Context, req CreateRequest, maxSize int) ([]byte, store.Meta, CreateResult, error) {
+	verdict, err := s.validate(ctx, req, maxSize)
+	if err != nil {
+		var ve *ValidationError
+		if errors.As(err, &ve) {
+			metrics.ValidationRejections.WithLabelValues(string(req.Channel), ve.Reason).Inc()
+		}
+		if len(verdict.Matched) > 0 {
+			slog.WarnContext(ctx, "paste rejected by filter", "rules", verdict.RuleIDs(), "channel", req.Channel, "remote", req.ClientIP)
+		}
+		return nil, store.Meta{}, CreateResult{}, err
+	}
+
+	meta := store.Meta{
+		ClientIP: req.ClientIP,
+		Channel:  string(req.Channel),
+		Owner:    req.Owner,
+		Burn:     req.Burn,
+		TTL:      req.TTL,
+	}
+	if len(verdict.Matched) > 0 {
+		meta.Flags = verdict.RuleIDs()
+	}
+	// A shorten_ttl rule never lengthens the lifetime the client asked for
+	if verdict.TTL > 0 && (meta.TTL == 0 || verdict.TTL < meta.TTL) {
+		meta.TTL = verdict.TTL
+	}
+
+	body, res, err := s.applySecretPolicy(ctx, req, &meta)
+	if err != nil {
+		return nil, store.Meta{}, CreateResult{}, err
+	}
+	meta.Size = len(body)
+	return body, meta, res, nil
+}
+
+// created records a stored paste and completes its result.
+func (s *Service) created(ctx context.Context, req CreateRequest, id string, meta store.Meta, res CreateResult) CreateResult {
+	slog.InfoContext(ctx, "created paste", "identifier", id, "channel", req.Channel, "remote", req.ClientIP, "size", meta.Size, "flags", meta.Flags, "burn", meta.Burn)
+	metrics.PastesCreated.WithLabelValues(string(req.Channel)).Inc()
+	metrics.PasteSize.WithLabelValues(string(req.Channel)).Observe(float64(meta.Size))
+	res.ID = id
+	res.URL = config.BaseURL + id
+	res.TTL = meta.TTL
+	return res
+}
+
+// validate checks the requested options and the body's size and scans it with
+// the content filter, returning the filter verdict for pastes that may be stored.
+func (s *Service) validate(ctx context.Context, req CreateRequest, maxSize int) (verdict filter.Verdict, err error) {
+	_, span := tracing.Start(ctx, "paste.Validate")
+	defer func() { tracing.End(span, err) }()
+
+	if err := validateTTL(req.TTL); err != nil {
+		return filter.Verdict{}, err
+	}
+	if err := validateSize(req.Body, maxSize); err != nil {
+		return filter.Verdict{}, err
+	}
+
+	verdict = s.filter.Scan(req.Body)
+	for _, m := range verdict.Matched {
+		metrics.FilterMatches.WithLabelValues(m.RuleID, m.Action).Inc()
+	}
+	span.SetAttributes(attribute.StringSlice("filter.matched", verdict.RuleIDs()))
+
+	if verdict.Action == filter.ActionReject {
+		return verdict, errBlacklisted
+	}
+	return verdict, nil
+}
+
+// applySecretPolicy scans the body for secrets and applies the configured policy.
+// It returns the body to store and a result describing what was found.
+func (s *Service) applySecretPolicy(ctx context.Context, req CreateRequest, meta *store.Meta) ([]byte, CreateResult, error) {
+	if s.opts.SecretPolicy == SecretsOff {
+		return req.Body, CreateResult{}, nil
+	}
+
+	_, span := tracing.Start(ctx, "paste.ScanSecrets")
+	findings := s.opts.Secrets.Scan(req.Body)
+	span.SetAttributes(attribute.Int("secrets.findings", len(findings)))
+	span.End()
+	if len(findings) == 0 {
+		return req.Body, CreateResult{}, nil
+	}
+
+	kinds := s.secretsDetected(ctx, req, findings)
+	res := CreateResult{Secrets: kinds, SecretPolicy: s.opts.SecretPolicy}
+	switch s.opts.SecretPolicy {
+	case SecretsReject:
+		return nil, CreateResult{}, secretsRejected(req, kinds)
+	case SecretsRedact:
+		return secrets.Redact(req.Body, findings), res, nil
+	case SecretsRestrict:
+		if meta.TTL == 0 || s.opts.SecretTTL < meta.TTL {
+			meta.TTL = s.opts.SecretTTL
+		}
+		return req.Body, res, nil
+	}
+	return req.Body, CreateResult{}, nil
+}
+
+// secretsDetected records findings in a paste, returning their kinds.
+func (s *Service) secretsDetected(ctx context.Context, req CreateRequest, findings []secrets.Finding) []string {
+	kinds := secrets.Kinds(findings)
+	for _, kind := range kinds {
+		metrics.SecretsDetected.WithLabelValues(kind, string(s.opts.SecretPolicy)).Inc()
+	}
+	slog.WarnContext(ctx, "secrets detected in paste", "kinds", kinds, "policy", s.opts.SecretPolicy, "channel", req.Channel, "remote", req.ClientIP)
+	return kinds
+}
+
+// secretsRejected returns the error for a paste refused by SecretsReject.
+func secretsRejected(req CreateRequest, kinds []string) error {
+	metrics.ValidationRejections.WithLabelValues(string(req.Channel), ReasonSecrets).Inc()
+	return &ValidationError{
+		StatusCode: http.StatusUnprocessableEntity,
+		Message:    "paste appears to contain secrets (" + strings.Join(kinds, ", ") + ")\nremove them and try again",
+		Reason:     ReasonSecrets,
+	}
+}
+
+// StatusCode maps an error returned by Service to an HTTP status code.
+func StatusCode(err error) int {
+	var ve *ValidationError
+	var gone *store.GoneError
+	switch {
+	case errors.As(err, &ve):
+		return ve.StatusCode
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrReadRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrBanned), errors.Is(err, ErrDenied):
+		return http.StatusForbidden
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrOffsetMismatch):
+		return http.StatusConflict
+	case errors.As(err, &gone):
+		return http.StatusGone
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Message returns the user-facing message for an error returned by Service.
+// Store failures are reduced to a generic message so backend details aren't leaked.
+func Message(err error) string {
+	var ve *ValidationError
+	var gone *store.GoneError
+	switch {
+	case errors.As(err, &ve):
+		return ve.Message
+	case errors.As(err, &gone):
+		return "removed: " + gone.Reason
+	case errors.Is(err, ErrRateLimited), errors.Is(err, ErrReadRateLimited), errors.Is(err, ErrBanned),
+		errors.Is(err, ErrDenied), errors.Is(err, ErrNotFound), errors.Is(err, ErrNoIdentifier),
+		errors.Is(err, ErrOffsetMismatch):
+		return err.Error()
+	default:
+		return "error"
+	}
+}
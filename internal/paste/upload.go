@@ -0,0 +1,260 @@
+package paste
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tombowditch/pastey-serv/internal/config"
+	"github.com/tombowditch/pastey-serv/internal/store"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
+	"github.com/tombowditch/pastey-serv/internal/util/randutil"
+)
+
+// ErrOffsetMismatch is returned when a chunk of a resumable upload doesn't
+// start where the data received so far ends.
+var ErrOffsetMismatch = errors.New("upload offset mismatch, check the current offset and resume from there")
+
+const (
+	// uploadPollInterval is how often a request waiting for another to store
+	// a complete upload checks whether it has.
+	uploadPollInterval = 250 * time.Millisecond
+	// uploadWaitTimeout bounds that wait.
+	uploadWaitTimeout = 30 * time.Second
+)
+
+var (
+	errUploadsUnavailable = &ValidationError{
+		StatusCode: http.StatusNotFound,
+		Message:    "resumable uploads are not available",
+		Reason:     ReasonBadOptions,
+	}
+	errUploadStoring = &ValidationError{
+		StatusCode: http.StatusConflict,
+		Message:    "upload is still being stored, check it again shortly",
+		Reason:     ReasonBadOptions,
+	}
+)
+
+// Upload describes a resumable upload: a paste larger than a single request
+// allows, or sent over an unreliable connection, received in chunks.
+type Upload struct {
+	ID     string
+	Length int64
+	// Offset is how many bytes have been received so far.
+	Offset int64
+	// Result describes the paste once the upload is complete.
+	Result *CreateResult
+}
+
+// UploadMaxSize returns the size limit for resumable uploads, or zero if they
+// are disabled.
+func (s *Service) UploadMaxSize() int {
+	if _, ok := s.store.(store.Uploader); !ok {
+		return 0
+	}
+	return s.opts.UploadMaxSize
+}
+
+// StartUpload begins a resumable upload of length bytes that will create a
+// paste with req's options. req.Body is ignored; callers should check
+// AllowCreate first, as for Create.
+func (s *Service) StartUpload(ctx context.Context, req CreateRequest, length int64) (u Upload, err error) {
+	ctx, span := tracing.Start(ctx, "paste.StartUpload", trace.WithAttributes(
+		attribute.String("paste.channel", string(req.Channel)),
+		attribute.Int64("upload.length", length),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	uploader, ok := s.store.(store.Uploader)
+	switch {
+	case !ok || s.opts.UploadMaxSize <= 0:
+		return Upload{}, errUploadsUnavailable
+	case length <= 0:
+		return Upload{}, &ValidationError{StatusCode: http.StatusBadRequest, Message: "invalid upload length", Reason: ReasonBadOptions}
+	case length > int64(s.opts.UploadMaxSize):
+		return Upload{}, &ValidationError{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Message:    "upload exceeds " + strconv.Itoa(s.opts.UploadMaxSize) + " bytes",
+			Reason:     ReasonTooLarge,
+		}
+	}
+	if err := validateTTL(req.TTL); err != nil {
+		return Upload{}, err
+	}
+
+	id := randutil.RandString(config.IDLengthSecure)
+	err = uploader.CreateUpload(ctx, store.Upload{
+		ID:       id,
+		Length:   length,
+		Secure:   req.Secure,
+		TTL:      req.TTL,
+		Burn:     req.Burn,
+		ClientIP: req.ClientIP,
+	}, config.UploadTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "store create upload failed", "error", err, "channel", req.Channel)
+		return Upload{}, errors.Join(ErrStore, err)
+	}
+	slog.InfoContext(ctx, "started upload", "upload", id, "length", length, "channel", req.Channel, "remote", req.ClientIP)
+	return Upload{ID: id, Length: length}, nil
+}
+
+// GetUpload returns an upload's progress. Errors are ErrNotFound or wrap ErrStore.
+func (s *Service) GetUpload(ctx context.Context, id string) (Upload, error) {
+	uploader, ok := s.store.(store.Uploader)
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	su, err := uploader.GetUpload(ctx, id)
+	if err != nil {
+		return Upload{}, uploadError(ctx, "get upload", id, err)
+	}
+	return uploadFromStore(su), nil
+}
+
+// AppendUpload adds data to an upload at offset. Once all data has arrived the
+// paste is validated and created as by Create, but with the resumable upload
+// size limit; if it is rejected the upload is discarded. Only one request
+// creates the paste: others completing the upload at the same time, or
+// repeating its final offset with no data, wait for and share its result.
+// Errors are ErrNotFound, ErrOffsetMismatch, a *ValidationError, or wrap
+// ErrStore.
+func (s *Service) AppendUpload(ctx context.Context, id string, offset int64, data []byte, channel Channel) (u Upload, err error) {
+	ctx, span := tracing.Start(ctx, "paste.AppendUpload", trace.WithAttributes(
+		attribute.String("upload.id", id),
+		attribute.Int64("upload.offset", offset),
+		attribute.Int("upload.chunk", len(data)),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	uploader, ok := s.store.(store.Uploader)
+	if !ok {
+		return Upload{}, ErrNotFound
+	}
+	su, err := uploader.GetUpload(ctx, id)
+	if err != nil {
+		return Upload{}, uploadError(ctx, "get upload", id, err)
+	}
+	if su.Claimed && offset == su.Length && len(data) == 0 {
+		return s.awaitUpload(ctx, uploader, su)
+	}
+	if su.Claimed || offset != su.Offset {
+		return uploadFromStore(su), ErrOffsetMismatch
+	}
+	if offset+int64(len(data)) > su.Length {
+		return uploadFromStore(su), &ValidationError{
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Message:    "data exceeds the upload length",
+			Reason:     ReasonTooLarge,
+		}
+	}
+
+	if len(data) > 0 {
+		su.Offset, err = uploader.AppendUpload(ctx, id, offset, data, config.UploadTTL)
+		if err != nil {
+			return uploadFromStore(su), uploadError(ctx, "append upload", id, err)
+		}
+	}
+	if su.Offset < su.Length {
+		return uploadFromStore(su), nil
+	}
+
+	claimed, err := uploader.ClaimUpload(ctx, id)
+	if err != nil {
+		return Upload{}, uploadError(ctx, "claim upload", id, err)
+	}
+	if !claimed {
+		return s.awaitUpload(ctx, uploader, su)
+	}
+
+	body, err := uploader.UploadData(ctx, id)
+	if err != nil {
+		return Upload{}, uploadError(ctx, "read upload", id, err)
+	}
+	res, err := s.create(ctx, CreateRequest{
+		Body:     body,
+		Secure:   su.Secure,
+		TTL:      su.TTL,
+		Burn:     su.Burn,
+		ClientIP: su.ClientIP,
+		Channel:  channel,
+	}, s.opts.UploadMaxSize)
+	if err != nil {
+		if err := uploader.DeleteUpload(ctx, id); err != nil {
+			slog.ErrorContext(ctx, "store delete upload failed", "error", err, "upload", id)
+		}
+		return Upload{}, err
+	}
+	if err := uploader.CompleteUpload(ctx, id, res.ID); err != nil {
+		// The paste exists; only looking it up again by upload fails
+		slog.ErrorContext(ctx, "store complete upload failed", "error", err, "upload", id)
+	}
+	return Upload{ID: id, Length: su.Length, Offset: su.Length, Result: &res}, nil
+}
+
+// awaitUpload waits for another request to finish storing a claimed upload
+// as a paste and returns its result, or errUploadStoring if that takes too
+// long. ErrNotFound means the paste was rejected and the upload discarded.
+func (s *Service) awaitUpload(ctx context.Context, uploader store.Uploader, su store.Upload) (Upload, error) {
+	ctx, cancel := context.WithTimeout(ctx, uploadWaitTimeout)
+	defer cancel()
+	t := time.NewTicker(uploadPollInterval)
+	defer t.Stop()
+
+	for su.PasteID == "" {
+		select {
+		case <-ctx.Done():
+			return uploadFromStore(su), errUploadStoring
+		case <-t.C:
+		}
+		next, err := uploader.GetUpload(ctx, su.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return uploadFromStore(su), errUploadStoring
+			}
+			return Upload{}, uploadError(ctx, "get upload", su.ID, err)
+		}
+		su = next
+	}
+	return uploadFromStore(su), nil
+}
+
+// DeleteUpload discards an unfinished upload. Errors are ErrNotFound or wrap ErrStore.
+func (s *Service) DeleteUpload(ctx context.Context, id string) error {
+	uploader, ok := s.store.(store.Uploader)
+	if !ok {
+		return ErrNotFound
+	}
+	if err := uploader.DeleteUpload(ctx, id); err != nil {
+		return uploadError(ctx, "delete upload", id, err)
+	}
+	return nil
+}
+
+func uploadFromStore(su store.Upload) Upload {
+	u := Upload{ID: su.ID, Length: su.Length, Offset: su.Offset}
+	if su.PasteID != "" {
+		u.Result = &CreateResult{ID: su.PasteID, URL: config.BaseURL + su.PasteID}
+	}
+	return u
+}
+
+// uploadError converts a store error from operation on an upload.
+func uploadError(ctx context.Context, operation, id string, err error) error {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(err, store.ErrOffsetMismatch):
+		return ErrOffsetMismatch
+	default:
+		slog.ErrorContext(ctx, "store "+operation+" failed", "error", err, "upload", id)
+		return errors.Join(ErrStore, err)
+	}
+}
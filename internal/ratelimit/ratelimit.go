@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tombowditch/pastey-serv/internal/metrics"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
+)
+
+// Limiter decides whether the client identified by key may perform another operation.
+type Limiter interface {
+	Allow(ctx context.Context, key string) bool
+}
+
+// tokenBucket refills KEYS[1] at ARGV[1] tokens per millisecond up to a capacity
+// of ARGV[2], then takes one token if available. State lives in a single hash so
+// the script stays within one hash slot on Redis Cluster.
+var tokenBucket = redis.NewScript(`
+local key = KEYS[1]
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", key, "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or 0
+
+local filled = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+if filled >= 1 then
+	filled = filled - 1
+	allowed = 1
+end
+
+redis.call("HSET", key, "tokens", tostring(filled), "ts", now)
+redis.call("PEXPIRE", key, math.ceil(capacity / rate) * 2)
+
+return allowed
+`)
+
+// RedisLimiter implements Limiter using a token bucket stored in Redis,
+// so limits are shared between every pastey instance and transport.
+type RedisLimiter struct {
+	client redis.UniversalClient
+	name   string
+	every  time.Duration
+	burst  int
+}
+
+// NewRedis creates a limiter allowing burst events, refilled once every interval.
+// Keys are namespaced by name so different limits don't share buckets.
+func NewRedis(client redis.UniversalClient, name string, every time.Duration, burst int) *RedisLimiter {
+	return &RedisLimiter{
+		client: client,
+		name:   name,
+		every:  every,
+		burst:  burst,
+	}
+}
+
+// Allow reports whether an event for key may happen now.
+// If Redis is unavailable the event is allowed, so an outage doesn't take pastey down with it.
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (allow bool) {
+	ctx, span := tracing.Start(ctx, "ratelimit.Allow", trace.WithAttributes(attribute.String("ratelimit.name", l.name)))
+	defer func() {
+		span.SetAttributes(attribute.Bool("ratelimit.allowed", allow))
+		span.End()
+	}()
+
+	perMilli := 1 / float64(l.every.Milliseconds())
+	now := time.Now().UnixMilli()
+	key = "pastey_" + l.name + "_rl_" + key
+
+	allowed, err := tokenBucket.Run(ctx, l.client, []string{key}, perMilli, l.burst, now).Int()
+	if err != nil {
+		slog.Error("rate limiter check failed", "error", err, "key", key)
+		span.RecordError(err)
+		return true
+	}
+	if allowed != 1 {
+		metrics.RateLimited.WithLabelValues(l.name).Inc()
+		return false
+	}
+	return true
+}
+
+// Ping checks that the Redis backing the limiter is reachable.
+func (l *RedisLimiter) Ping(ctx context.Context) error {
+	return l.client.Ping(ctx).Err()
+}
@@ -0,0 +1,59 @@
+// Package reload keeps a configuration document loaded from a file or store
+// up to date, installing it again whenever it changes.
+package reload
+
+import (
+	"bytes"
+	"context"
+	"time"
+)
+
+// Loader loads, parses and installs one kind of document.
+type Loader[T any] struct {
+	// Load returns the current document.
+	Load func(ctx context.Context) ([]byte, error)
+	// Parse decodes a document.
+	Parse func(data []byte) (T, error)
+	// Install makes a parsed document active. On error the current one is kept.
+	Install func(ctx context.Context, v T) error
+}
+
+// Reload loads the document and installs it if it changed since last.
+// It returns the document that is now active.
+func (l Loader[T]) Reload(ctx context.Context, last []byte) ([]byte, error) {
+	data, err := l.Load(ctx)
+	if err != nil {
+		return last, err
+	}
+	if bytes.Equal(data, last) {
+		return last, nil
+	}
+
+	v, err := l.Parse(data)
+	if err != nil {
+		return last, err
+	}
+	if err := l.Install(ctx, v); err != nil {
+		return last, err
+	}
+	return data, nil
+}
+
+// Watch reloads the document every interval until ctx is done, passing
+// errors to failed; the previous document stays active.
+func (l Loader[T]) Watch(ctx context.Context, interval time.Duration, last []byte, failed func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			var err error
+			if last, err = l.Reload(ctx, last); err != nil {
+				failed(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package render
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxRows bounds the rows shown for a CSV paste.
+const maxRows = 10_000
+
+// renderCSV shows CSV as a table headed by its first row. Tab- and
+// semicolon-separated values are recognised too.
+func renderCSV(v *view, body string) {
+	r := csvReader(body)
+	r.FieldsPerRecord = -1
+	for len(v.Table) <= maxRows {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line := 0
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				line = perr.Line
+			}
+			v.Error = "invalid CSV: " + err.Error()
+			v.Table = nil
+			v.Source = source(body, line)
+			return
+		}
+		v.Table = append(v.Table, record)
+	}
+
+	switch {
+	case len(v.Table) == 0:
+		v.Status = "empty CSV"
+	case len(v.Table) > maxRows:
+		v.Table = v.Table[:maxRows]
+		v.Status = fmt.Sprintf("showing the first %d rows; see the raw paste for the rest", maxRows-1)
+	default:
+		v.Status = fmt.Sprintf("%d rows", len(v.Table)-1)
+	}
+}
+
+// csvReader reads body with the separator its first line uses most.
+func csvReader(body string) *csv.Reader {
+	first, _, _ := strings.Cut(body, "\n")
+	comma := ','
+	for _, sep := range []rune{'\t', ';'} {
+		if strings.Count(first, string(sep)) > strings.Count(first, string(comma)) {
+			comma = sep
+		}
+	}
+	r := csv.NewReader(strings.NewReader(body))
+	r.Comma = comma
+	r.LazyQuotes = true
+	return r
+}
@@ -0,0 +1,77 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v3"
+)
+
+// yamlLine finds the line number in a YAML error such as
+// "yaml: line 3: mapping values are not allowed in this context".
+var yamlLine = regexp.MustCompile(`line (\d+)`)
+
+// renderYAML checks YAML, which may hold several documents, and shows the
+// source with any error marked.
+func renderYAML(v *view, body string) {
+	if tooDeep(body) {
+		plain(v, body)
+		return
+	}
+	dec := yaml.NewDecoder(strings.NewReader(body))
+	docs := 0
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			line := 0
+			if m := yamlLine.FindStringSubmatch(err.Error()); m != nil {
+				line, _ = strconv.Atoi(m[1])
+			}
+			v.Error = "invalid YAML: " + strings.TrimPrefix(err.Error(), "yaml: ")
+			v.Source = source(body, line)
+			return
+		}
+		docs++
+	}
+
+	v.Status = "valid YAML"
+	if docs > 1 {
+		v.Status += fmt.Sprintf(" (%d documents)", docs)
+	}
+	v.Source = source(body, 0)
+}
+
+// renderTOML checks TOML and shows the source with any error marked.
+func renderTOML(v *view, body string) {
+	if tooDeep(body) {
+		plain(v, body)
+		return
+	}
+	var data map[string]any
+	if _, err := toml.Decode(body, &data); err != nil {
+		line := 0
+		var perr toml.ParseError
+		if errors.As(err, &perr) {
+			line = perr.Position.Line
+			err = errors.New(perr.Message)
+		}
+		v.Error = "invalid TOML: " + err.Error()
+		if line > 0 {
+			v.Error = fmt.Sprintf("invalid TOML on line %d: %v", line, err)
+		}
+		v.Source = source(body, line)
+		return
+	}
+
+	v.Status = "valid TOML"
+	v.Source = source(body, 0)
+}
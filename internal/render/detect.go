@@ -0,0 +1,81 @@
+package render
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v3"
+)
+
+var (
+	tomlLine = regexp.MustCompile(`(?m)^\s*(\[[\w."-]+\]|[\w."-]+\s*=\s*\S)`)
+	yamlKey  = regexp.MustCompile(`(?m)^[\w"'-][^:#\n]*:(\s|$)`)
+	// markdownStrong matches constructs rarely found outside Markdown:
+	// fenced code, links, table rules and second-level headings.
+	markdownStrong = regexp.MustCompile("(?m)^```|\\]\\([^)\\s]+\\)|^\\|?\\s*:?-{3,}:?\\s*\\||^#{2,6} \\S")
+	// markdownWeak matches headings, lists and quotes.
+	markdownWeak = regexp.MustCompile(`(?m)^(#{1,6} \S|\s*[-*+] \S|\s*\d+\. \S|> )`)
+)
+
+// Detect guesses a paste's format from its content, falling back to Text.
+func Detect(body string) Format {
+	trimmed := strings.TrimSpace(body)
+	switch {
+	case trimmed == "":
+		return Text
+	case (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(trimmed)):
+		return JSON
+	case isTOML(body):
+		return TOML
+	case looksLikeJSON(trimmed):
+		// Shown as JSON so the error is pointed out
+		return JSON
+	case markdownStrong.MatchString(body):
+		return Markdown
+	case isYAML(body):
+		return YAML
+	case isCSV(body):
+		return CSV
+	case markdownWeak.MatchString(body):
+		return Markdown
+	}
+	return Text
+}
+
+func looksLikeJSON(trimmed string) bool {
+	first, last := trimmed[0], trimmed[len(trimmed)-1]
+	return (first == '{' && last == '}') || (first == '[' && last == ']')
+}
+
+func isTOML(body string) bool {
+	if !tomlLine.MatchString(body) || tooDeep(body) {
+		return false
+	}
+	var data map[string]any
+	_, err := toml.Decode(body, &data)
+	return err == nil && len(data) > 0
+}
+
+// isYAML reports whether body is a YAML mapping. Plain text parses as a YAML
+// string and Markdown lists as sequences, so neither counts.
+func isYAML(body string) bool {
+	if !yamlKey.MatchString(body) || tooDeep(body) {
+		return false
+	}
+	var data any
+	if err := yaml.Unmarshal([]byte(body), &data); err != nil {
+		return false
+	}
+	_, ok := data.(map[string]any)
+	return ok
+}
+
+// isCSV reports whether body has at least two lines of two or more fields,
+// with the same number of fields on each.
+func isCSV(body string) bool {
+	r := csvReader(body)
+	records, err := r.ReadAll()
+	return err == nil && len(records) >= 2 && len(records[0]) >= 2
+}
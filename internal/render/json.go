@@ -0,0 +1,119 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+var (
+	errTrailingData = errors.New("unexpected data after the top-level value")
+	errTooDeep      = errors.New("nested too deeply")
+)
+
+// renderJSON shows valid JSON as a tree whose objects and arrays can be
+// collapsed, keeping the order of keys, or the source with the error marked.
+func renderJSON(v *view, body string) {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber()
+
+	var b strings.Builder
+	b.WriteString(`<div class="json">`)
+	err := writeJSON(&b, dec, 0)
+	if errors.Is(err, errTooDeep) {
+		plain(v, body)
+		return
+	}
+	if err == nil {
+		if _, extra := dec.Token(); extra != io.EOF {
+			err = errTrailingData
+		}
+	}
+	if err != nil {
+		offset := dec.InputOffset()
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			offset = syntax.Offset
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		line := 1 + strings.Count(body[:min(int(offset), len(body))], "\n")
+		v.Error = fmt.Sprintf("invalid JSON on line %d: %v", line, err)
+		v.Source = source(body, line)
+		return
+	}
+	b.WriteString(`</div>`)
+
+	v.Status = "valid JSON"
+	v.HTML = template.HTML(b.String())
+}
+
+// writeJSON writes the next value from dec, which is nested in depth
+// objects and arrays.
+func writeJSON(b *strings.Builder, dec *json.Decoder, depth int) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok := tok.(type) {
+	case json.Delim:
+		if depth >= maxDepth {
+			return errTooDeep
+		}
+		open, end := "{", "}"
+		if tok == '[' {
+			open, end = "[", "]"
+		}
+		if !dec.More() {
+			b.WriteString(open + end)
+			_, err := dec.Token()
+			return err
+		}
+
+		b.WriteString("<details open><summary>" + open + "</summary>")
+		for dec.More() {
+			b.WriteString(`<div class="member">`)
+			if tok == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				b.WriteString(`<span class="key">` + template.HTMLEscapeString(quote(key.(string))) + `</span>: `)
+			}
+			if err := writeJSON(b, dec, depth+1); err != nil {
+				return err
+			}
+			if dec.More() {
+				b.WriteString(",")
+			}
+			b.WriteString("</div>")
+		}
+		b.WriteString("</details>" + end)
+		_, err := dec.Token()
+		return err
+	case string:
+		b.WriteString(`<span class="string">` + template.HTMLEscapeString(quote(tok)) + `</span>`)
+	case json.Number:
+		b.WriteString(`<span class="number">` + template.HTMLEscapeString(tok.String()) + `</span>`)
+	case bool:
+		b.WriteString(fmt.Sprintf(`<span class="literal">%t</span>`, tok))
+	case nil:
+		b.WriteString(`<span class="literal">null</span>`)
+	}
+	return nil
+}
+
+// quote formats s as a JSON string, leaving HTML escaping to the caller.
+func quote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,49 @@
+package render
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+// markdown renders GitHub Flavored Markdown. Raw HTML in the paste is left
+// out and links with dangerous schemes such as javascript: are dropped, as
+// goldmark does unless told the content is trusted.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+func renderMarkdown(v *view, body string) {
+	// goldmark's work grows with the square of how deeply quotes nest
+	if quotesTooDeep(body) {
+		plain(v, body)
+		return
+	}
+	var b bytes.Buffer
+	if err := markdown.Convert([]byte(body), &b); err != nil {
+		v.Error = "rendering markdown: " + err.Error()
+		v.Source = source(body, 0)
+		return
+	}
+	v.HTML = template.HTML(`<div class="markdown">` + b.String() + `</div>`)
+}
+
+// quotesTooDeep reports whether any line of body starts with more than
+// maxDepth nested block quotes.
+func quotesTooDeep(body string) bool {
+	depth, start := 0, true
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case c == '\n':
+			depth, start = 0, true
+		case !start:
+		case c == '>':
+			if depth++; depth > maxDepth {
+				return true
+			}
+		case c != ' ' && c != '\t':
+			start = false
+		}
+	}
+	return false
+}
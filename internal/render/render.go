@@ -0,0 +1,169 @@
+// Package render shows pastes as HTML pages according to their content:
+// Markdown as formatted text, JSON as a collapsible tree, YAML and TOML
+// checked for errors, and CSV as a table.
+package render
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+)
+
+// MaxSize is the largest paste that is rendered. Bigger pastes are only
+// available raw.
+const MaxSize = 2_000_000
+
+// maxDepth is the deepest nesting of JSON, YAML or TOML values that is
+// checked or shown as a tree. Deeper content is shown as plain text.
+const maxDepth = 1000
+
+// Format is a kind of content a paste can be rendered as.
+type Format string
+
+const (
+	Text     Format = "text"
+	Markdown Format = "markdown"
+	JSON     Format = "json"
+	YAML     Format = "yaml"
+	TOML     Format = "toml"
+	CSV      Format = "csv"
+)
+
+// ParseFormat returns the format with the given name or common alias, such
+// as "md" or "yml".
+func ParseFormat(name string) (Format, bool) {
+	switch strings.ToLower(name) {
+	case "text", "txt":
+		return Text, true
+	case "markdown", "md":
+		return Markdown, true
+	case "json":
+		return JSON, true
+	case "yaml", "yml":
+		return YAML, true
+	case "toml":
+		return TOML, true
+	case "csv", "tsv":
+		return CSV, true
+	}
+	return "", false
+}
+
+// view is what a page shows for a paste.
+type view struct {
+	Title  string
+	RawURL string
+	Format Format
+	// Status summarizes the content, such as whether it is valid.
+	Status string
+	// Error describes why the content couldn't be parsed; Source marks
+	// the line at fault.
+	Error  string
+	Source []sourceLine
+	// HTML is rendered content, already safe to include.
+	HTML template.HTML
+	// Table holds CSV rows, the first being the header.
+	Table [][]string
+}
+
+type sourceLine struct {
+	Number int
+	Text   string
+	Error  bool
+}
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body{margin:0;font:15px/1.5 system-ui,sans-serif;color:#222;background:#fff}
+header{padding:.5em 1em;background:#f3f3f3;border-bottom:1px solid #ddd;font-size:13px}
+main{padding:1em;overflow-x:auto}
+pre,code,.source,.json{font:13px/1.4 monospace}
+pre{padding:.5em;background:#f6f8fa;overflow-x:auto}
+.status{color:#1a7f37}
+.error{color:#cf222e}
+.source{border-collapse:collapse}
+.source td{padding:0 .5em;white-space:pre-wrap;vertical-align:top}
+.source .ln{color:#999;text-align:right;user-select:none}
+.source tr.error{background:#ffebe9}
+.json details{display:inline}
+.json summary{display:inline;cursor:pointer}
+.json details:not([open])>summary::after{content:" … "}
+.json .member{padding-left:2em}
+.json .key{color:#0550ae}
+.json .string{color:#0a3069}
+.json .number,.json .literal{color:#953800}
+table.csv{border-collapse:collapse}
+table.csv th,table.csv td,.markdown table th,.markdown table td{border:1px solid #ddd;padding:.2em .6em}
+table.csv th{background:#f3f3f3}
+</style></head>
+<body><header>{{.Title}} · {{.Format}} · <a href="{{.RawURL}}">raw</a></header>
+<main>
+{{- with .Status}}<p class="status">{{.}}</p>{{end}}
+{{- with .Error}}<p class="error">{{.}}</p>{{end}}
+{{- with .Source}}<table class="source">{{range .}}<tr{{if .Error}} class="error"{{end}}><td class="ln">{{.Number}}</td><td>{{.Text}}</td></tr>{{end}}</table>{{end}}
+{{- .HTML}}
+{{- with .Table}}<table class="csv"><thead><tr>{{range index . 0}}<th>{{.}}</th>{{end}}</tr></thead><tbody>
+{{- range slice . 1}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>{{end}}</tbody></table>{{end}}
+</main></body></html>
+`))
+
+// Page renders body, which should already be free of terminal escape
+// sequences, as a standalone HTML page in format f. title names the paste and
+// rawURL links to its raw content.
+func Page(title, rawURL string, f Format, body string) []byte {
+	v := view{Title: title, RawURL: rawURL, Format: f}
+	switch f {
+	case Markdown:
+		renderMarkdown(&v, body)
+	case JSON:
+		renderJSON(&v, body)
+	case YAML:
+		renderYAML(&v, body)
+	case TOML:
+		renderTOML(&v, body)
+	case CSV:
+		renderCSV(&v, body)
+	default:
+		v.HTML = template.HTML("<pre>" + template.HTMLEscapeString(body) + "</pre>")
+	}
+
+	var b bytes.Buffer
+	// The view's fields can't fail to execute
+	page.Execute(&b, v)
+	return b.Bytes()
+}
+
+// tooDeep reports whether brackets and braces in body nest deeper than
+// maxDepth. Those in strings are counted too, so it may overestimate.
+func tooDeep(body string) bool {
+	depth := 0
+	for i := 0; i < len(body); i++ {
+		switch body[i] {
+		case '[', '{':
+			if depth++; depth > maxDepth {
+				return true
+			}
+		case ']', '}':
+			depth = max(depth-1, 0)
+		}
+	}
+	return false
+}
+
+// plain shows body as text because it nests too deeply to render.
+func plain(v *view, body string) {
+	v.Status = "nested too deeply to render; shown as text"
+	v.Source = source(body, 0)
+}
+
+// source lists body's lines, marking errLine (counting from 1) if it is non-zero.
+func source(body string, errLine int) []sourceLine {
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	out := make([]sourceLine, len(lines))
+	for i, line := range lines {
+		out[i] = sourceLine{Number: i + 1, Text: line, Error: i+1 == errLine}
+	}
+	return out
+}
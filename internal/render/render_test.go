@@ -0,0 +1,200 @@
+package render
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		want Format
+		ok   bool
+	}{
+		{name: "md", want: Markdown, ok: true},
+		{name: "Markdown", want: Markdown, ok: true},
+		{name: "json", want: JSON, ok: true},
+		{name: "yml", want: YAML, ok: true},
+		{name: "toml", want: TOML, ok: true},
+		{name: "tsv", want: CSV, ok: true},
+		{name: "txt", want: Text, ok: true},
+		{name: "view"},
+		{name: "html"},
+		{name: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseFormat(tt.name)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ParseFormat(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Format
+	}{
+		{name: "empty", body: "", want: Text},
+		{name: "blank", body: " \n\t\n", want: Text},
+		{name: "plain text", body: "just some words\nand more words\n", want: Text},
+		{name: "json object", body: `{"a": [1, 2]}`, want: JSON},
+		{name: "json array", body: "\n[1, 2, 3]\n", want: JSON},
+		{name: "invalid json", body: `{"a": 1,}`, want: JSON},
+		{name: "toml", body: "[server]\nport = 8080\n", want: TOML},
+		{name: "yaml", body: "name: pastey\nports:\n  - 80\n", want: YAML},
+		{name: "yaml list only", body: "- a\n- b\n", want: Markdown},
+		{name: "markdown heading", body: "## Usage\n\nrun it\n", want: Markdown},
+		{name: "markdown link", body: "see [docs](https://example.com)\n", want: Markdown},
+		{name: "markdown fence", body: "```\ncode\n```\n", want: Markdown},
+		{name: "csv", body: "a,b,c\n1,2,3\n4,5,6\n", want: CSV},
+		{name: "tsv", body: "a\tb\n1\t2\n", want: CSV},
+		{name: "ragged csv", body: "a,b\n1,2,3\n", want: Text},
+		{name: "deep toml-like", body: "a = " + strings.Repeat("[", maxDepth+1) + "\n", want: Text},
+		{name: "deep yaml-like", body: "a: " + strings.Repeat("[", maxDepth+1) + "\n", want: Text},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.body); got != tt.want {
+				t.Errorf("Detect(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	deepJSON := strings.Repeat("[", maxDepth+1) + strings.Repeat("]", maxDepth+1)
+	deepestJSON := strings.Repeat("[", maxDepth) + "1" + strings.Repeat("]", maxDepth)
+	deepQuote := strings.Repeat(">", maxDepth+1) + " quoted\n"
+
+	tests := []struct {
+		name   string
+		format Format
+		body   string
+		// status and err are the view's Status and Error, which must contain
+		// them if set and be empty otherwise.
+		status string
+		err    string
+		// errLine is the source line marked as wrong, or zero.
+		errLine int
+		// html is contained in the rendered content.
+		html string
+	}{
+		{name: "json", format: JSON, body: `{"b": 1, "a": [true, null, "<x>"]}`, status: "valid JSON",
+			html: `<span class="key">&#34;b&#34;</span>: <span class="number">1</span>`},
+		{name: "json escaped", format: JSON, body: `["<script>"]`, status: "valid JSON", html: "&lt;script&gt;"},
+		{name: "json empty containers", format: JSON, body: `{"a": {}, "b": []}`, status: "valid JSON", html: "{}"},
+		{name: "json syntax error", format: JSON, body: "{\n  \"a\": 1\n  \"b\": 2\n}\n", err: "invalid JSON on line 3", errLine: 3},
+		{name: "json truncated", format: JSON, body: "{\"a\": [1,", err: "unexpected end of JSON input", errLine: 1},
+		{name: "json trailing data", format: JSON, body: "{}\n{}\n", err: "unexpected data after the top-level value", errLine: 2},
+		{name: "json deepest", format: JSON, body: deepestJSON, status: "valid JSON"},
+		{name: "json too deep", format: JSON, body: deepJSON, status: "nested too deeply"},
+		{name: "yaml", format: YAML, body: "a: 1\nb: [2, 3]\n", status: "valid YAML"},
+		{name: "yaml documents", format: YAML, body: "a: 1\n---\nb: 2\n", status: "valid YAML (2 documents)"},
+		{name: "yaml error", format: YAML, body: "a: 1\nb: : 2\n", err: "invalid YAML", errLine: 2},
+		{name: "yaml too deep", format: YAML, body: "a: " + deepJSON, status: "nested too deeply"},
+		{name: "toml", format: TOML, body: "[a]\nb = 1\n", status: "valid TOML"},
+		{name: "toml error", format: TOML, body: "a = 1\nb = \n", err: "invalid TOML on line 2", errLine: 2},
+		{name: "toml too deep", format: TOML, body: "a = " + deepJSON, status: "nested too deeply"},
+		{name: "csv", format: CSV, body: "h1,h2\n1,2\n3,4\n", status: "2 rows", html: ""},
+		{name: "csv empty", format: CSV, body: "", status: "empty CSV"},
+		{name: "csv ragged rows", format: CSV, body: "a,b\n1\n2,3,4\n", status: "2 rows"},
+		{name: "csv lazy quotes", format: CSV, body: "a,b\nx\"y,1\n", status: "1 rows"},
+		{name: "markdown", format: Markdown, body: "# Title\n\n- item\n", html: "<h1>Title</h1>"},
+		{name: "markdown raw html dropped", format: Markdown, body: "<script>alert(1)</script>\n", html: "raw HTML omitted"},
+		{name: "markdown unsafe link dropped", format: Markdown, body: "[x](javascript:alert(1))\n", html: `<a href="">x</a>`},
+		{name: "markdown deepest quote", format: Markdown, body: strings.Repeat(">", 50) + " q\n", html: "<blockquote>"},
+		{name: "markdown quotes too deep", format: Markdown, body: deepQuote, status: "nested too deeply"},
+	}
+
+	renderers := map[Format]func(*view, string){
+		JSON:     renderJSON,
+		YAML:     renderYAML,
+		TOML:     renderTOML,
+		CSV:      renderCSV,
+		Markdown: renderMarkdown,
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v view
+			renderers[tt.format](&v, tt.body)
+
+			if !contains(v.Status, tt.status) {
+				t.Errorf("Status = %q, want %q", v.Status, tt.status)
+			}
+			if !contains(v.Error, tt.err) {
+				t.Errorf("Error = %q, want %q", v.Error, tt.err)
+			}
+			if !strings.Contains(string(v.HTML), tt.html) {
+				t.Errorf("HTML = %q, want it to contain %q", v.HTML, tt.html)
+			}
+			marked := 0
+			for _, line := range v.Source {
+				if line.Error {
+					marked = line.Number
+				}
+			}
+			if marked != tt.errLine {
+				t.Errorf("marked line %d, want %d", marked, tt.errLine)
+			}
+		})
+	}
+}
+
+// contains reports whether s contains want, or is empty if want is.
+func contains(s, want string) bool {
+	if want == "" {
+		return s == ""
+	}
+	return strings.Contains(s, want)
+}
+
+func TestCSVRowLimit(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("n,square\n")
+	for i := 0; i < maxRows+10; i++ {
+		b.WriteString("1,1\n")
+	}
+	var v view
+	renderCSV(&v, b.String())
+	if len(v.Table) != maxRows {
+		t.Errorf("got %d rows, want %d", len(v.Table), maxRows)
+	}
+	if !strings.Contains(v.Status, "showing the first") {
+		t.Errorf("Status = %q, want a truncation notice", v.Status)
+	}
+}
+
+func TestPage(t *testing.T) {
+	tests := []struct {
+		name   string
+		title  string
+		format Format
+		body   string
+		want   []string
+	}{
+		{name: "text escaped", title: "abc", format: Text, body: "<b>&</b>",
+			want: []string{"<pre>&lt;b&gt;&amp;&lt;/b&gt;</pre>", "abc · text · "}},
+		{name: "title escaped", title: "<i>", format: Text, body: "x",
+			want: []string{"<title>&lt;i&gt;</title>"}},
+		{name: "raw link", title: "abc", format: JSON, body: "[1]",
+			want: []string{`<a href="/abc">raw</a>`, `<p class="status">valid JSON</p>`}},
+		{name: "error source", title: "abc", format: JSON, body: "[1,\n<oops>\n",
+			want: []string{`<p class="error">invalid JSON on line 2`, `<tr class="error"><td class="ln">2</td><td>&lt;oops&gt;</td></tr>`}},
+		{name: "csv table", title: "abc", format: CSV, body: "<h>,b\n1,2\n",
+			want: []string{"<th>&lt;h&gt;</th>", "<td>1</td><td>2</td>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Page(tt.title, "/"+tt.title, tt.format, tt.body))
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Page() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"regexp"
+	"sort"
+)
+
+// Finding is a detected secret at body[Start:End].
+type Finding struct {
+	Kind  string
+	Start int
+	End   int
+}
+
+// Detector finds one family of credentials. Implementations must be safe for concurrent use.
+type Detector interface {
+	Find(body []byte) []Finding
+}
+
+// RegexDetector reports every match of Pattern as Kind.
+type RegexDetector struct {
+	Kind    string
+	Pattern *regexp.Regexp
+}
+
+// Find returns the matches of the detector's pattern.
+func (d RegexDetector) Find(body []byte) []Finding {
+	var findings []Finding
+	for _, loc := range d.Pattern.FindAllIndex(body, -1) {
+		findings = append(findings, Finding{Kind: d.Kind, Start: loc[0], End: loc[1]})
+	}
+	return findings
+}
+
+// EntropyDetector reports long token-like strings whose Shannon entropy suggests
+// randomly generated credentials rather than words or identifiers. Encoded
+// data that is as random but not secret is skipped: SSH public keys, and
+// base64 too long or wrapped over several lines to be a credential.
+type EntropyDetector struct {
+	// MinLength and MaxLength bound the tokens considered. A MaxLength of
+	// zero means unlimited.
+	MinLength int
+	MaxLength int
+	// Threshold is the minimum entropy in bits per character.
+	Threshold float64
+}
+
+var tokenPattern = regexp.MustCompile(`[A-Za-z0-9+/=_\-]+`)
+
+// Find returns tokens exceeding the entropy threshold.
+func (d EntropyDetector) Find(body []byte) []Finding {
+	var findings []Finding
+	for _, loc := range tokenPattern.FindAllIndex(body, -1) {
+		tok := body[loc[0]:loc[1]]
+		if len(tok) < d.MinLength || (d.MaxLength > 0 && len(tok) > d.MaxLength) || !mixedClasses(tok) {
+			continue
+		}
+		if sshPublicKey(tok) || wrapped(body, loc[0], loc[1]) {
+			continue
+		}
+		if entropy(tok) >= d.Threshold {
+			findings = append(findings, Finding{Kind: "high_entropy", Start: loc[0], End: loc[1]})
+		}
+	}
+	return findings
+}
+
+// mixedClasses reports whether tok has lowercase, uppercase and digits,
+// which rules out hex hashes and most natural identifiers.
+func mixedClasses(tok []byte) bool {
+	var lower, upper, digit bool
+	for _, c := range tok {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		}
+	}
+	return lower && upper && digit
+}
+
+// sshPublicKey reports whether tok is a base64 SSH public key, as in
+// authorized_keys files, whose wire format starts with the key type.
+func sshPublicKey(tok []byte) bool {
+	if len(tok) < 16 {
+		return false
+	}
+	head := make([]byte, 12)
+	if _, err := base64.StdEncoding.Decode(head, tok[:16]); err != nil {
+		return false
+	}
+	n := binary.BigEndian.Uint32(head)
+	kind := head[4:]
+	return n >= 4 && n <= 64 &&
+		(bytes.HasPrefix(kind, []byte("ssh-")) || bytes.HasPrefix(kind, []byte("ecdsa-")) || bytes.HasPrefix(kind, []byte("sk-")))
+}
+
+// wrapped reports whether body[start:end] is a whole line of a block of
+// encoded data wrapped at a fixed width, as in PEM certificates or MIME
+// attachments: the line before or after it is token characters of the same
+// width, or it is the shorter last line after two such lines.
+func wrapped(body []byte, start, end int) bool {
+	if start > 0 && body[start-1] != '\n' {
+		return false
+	}
+	rest := bytes.TrimPrefix(body[end:], []byte("\r"))
+	if len(rest) > 0 && rest[0] != '\n' {
+		return false
+	}
+
+	width := end - start
+	next, _, _ := bytes.Cut(bytes.TrimPrefix(rest, []byte("\n")), []byte("\n"))
+	prev, above := lastLine(body[:start])
+	switch {
+	case encodedLine(next, width), encodedLine(prev, width):
+		return true
+	case len(prev) > width:
+		prevPrev, _ := lastLine(above)
+		return encodedLine(prev, len(prev)) && encodedLine(prevPrev, len(prev))
+	}
+	return false
+}
+
+// lastLine splits b, which is empty or ends with a newline, into its last
+// line and the lines before it.
+func lastLine(b []byte) (line, before []byte) {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	i := bytes.LastIndexByte(b, '\n') + 1
+	return bytes.TrimSuffix(b[i:], []byte("\r")), b[:i]
+}
+
+// encodedLine reports whether line is width token characters.
+func encodedLine(line []byte, width int) bool {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	return len(line) == width && len(tokenPattern.Find(line)) == width
+}
+
+// entropy returns the Shannon entropy of b in bits per byte.
+func entropy(b []byte) float64 {
+	var counts [256]int
+	for _, c := range b {
+		counts[c]++
+	}
+	var h float64
+	n := float64(len(b))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// DefaultDetectors covers the credential formats most often pasted by mistake.
+func DefaultDetectors() []Detector {
+	return []Detector{
+		RegexDetector{Kind: "aws_access_key", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		RegexDetector{Kind: "private_key", Pattern: regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?(?:-----END [A-Z0-9 ]*PRIVATE KEY(?: BLOCK)?-----|\z)`)},
+		RegexDetector{Kind: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`)},
+		RegexDetector{Kind: "github_token", Pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{82})\b`)},
+		EntropyDetector{MinLength: 32, MaxLength: 256, Threshold: 4.5},
+	}
+}
+
+// Scanner runs a set of detectors over paste bodies.
+type Scanner struct {
+	detectors []Detector
+}
+
+// NewScanner creates a scanner using detectors.
+func NewScanner(detectors ...Detector) *Scanner {
+	return &Scanner{detectors: detectors}
+}
+
+// Scan returns all findings ordered by position, with overlapping findings
+// merged into the earliest (so a JWT isn't also reported as high entropy).
+func (s *Scanner) Scan(body []byte) []Finding {
+	var all []Finding
+	for _, d := range s.detectors {
+		all = append(all, d.Find(body)...)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].Start != all[j].Start {
+			return all[i].Start < all[j].Start
+		}
+		return all[i].End > all[j].End
+	})
+
+	var merged []Finding
+	for _, f := range all {
+		if n := len(merged); n > 0 && f.Start < merged[n-1].End {
+			if f.End > merged[n-1].End {
+				merged[n-1].End = f.End
+			}
+			continue
+		}
+		merged = append(merged, f)
+	}
+	return merged
+}
+
+// Kinds returns the distinct kinds of findings in order of first appearance.
+func Kinds(findings []Finding) []string {
+	var kinds []string
+	seen := make(map[string]bool)
+	for _, f := range findings {
+		if !seen[f.Kind] {
+			seen[f.Kind] = true
+			kinds = append(kinds, f.Kind)
+		}
+	}
+	return kinds
+}
+
+// Redact returns a copy of body with each finding replaced by a marker naming its kind.
+// Findings must be ordered and non-overlapping, as returned by Scan.
+func Redact(body []byte, findings []Finding) []byte {
+	var buf bytes.Buffer
+	buf.Grow(len(body))
+	last := 0
+	for _, f := range findings {
+		buf.Write(body[last:f.Start])
+		buf.WriteString("[REDACTED " + f.Kind + "]")
+		last = f.End
+	}
+	buf.Write(body[last:])
+	return buf.Bytes()
+}
@@ -0,0 +1,29 @@
+package adminserver
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/tombowditch/pastey-serv/internal/health"
+)
+
+// NewHandler creates the handler for the admin listener.
+// It is served separately from the public HTTP server so operational
+// endpoints can be kept off the public network.
+//
+// The moderation API under /admin/ is only enabled when tokens (bearer token
+// to operator name) is non-empty; every call to it is written to audit.
+func NewHandler(h *health.Checker, s ModerationStore, tokens map[string]string, audit *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.Handle("GET /healthz", h.LiveHandler())
+	mux.Handle("GET /readyz", h.ReadyHandler())
+
+	if len(tokens) > 0 {
+		m := &moderation{store: s, tokens: tokens, audit: audit}
+		m.register(mux)
+	}
+	return mux
+}
@@ -0,0 +1,280 @@
+package adminserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tombowditch/pastey-serv/internal/store"
+)
+
+const (
+	defaultRecentLimit = 50
+	maxRecentLimit     = 1000
+	maxRequestBody     = 64 << 10
+)
+
+// ModerationStore is the storage the moderation API operates on.
+type ModerationStore interface {
+	store.Store
+	store.Moderator
+	store.Banlist
+}
+
+// moderation serves the authenticated admin API used to handle abuse reports.
+type moderation struct {
+	store  ModerationStore
+	tokens map[string]string
+	audit  *slog.Logger
+}
+
+type actorKey struct{}
+
+// register adds the moderation routes to mux.
+func (m *moderation) register(mux *http.ServeMux) {
+	mux.Handle("GET /admin/pastes", m.authed("list_recent", m.listRecent))
+	mux.Handle("GET /admin/pastes/{id}", m.authed("view_meta", m.viewMeta))
+	mux.Handle("GET /admin/pastes/{id}/content", m.authed("view_content", m.viewContent))
+	mux.Handle("DELETE /admin/pastes/{id}", m.authed("delete", m.deletePaste))
+	mux.Handle("POST /admin/pastes/{id}/tombstone", m.authed("tombstone", m.tombstone))
+	mux.Handle("GET /admin/bans/{ip}", m.authed("view_ban", m.viewBan))
+	mux.Handle("PUT /admin/bans/{ip}", m.authed("ban", m.ban))
+	mux.Handle("DELETE /admin/bans/{ip}", m.authed("unban", m.unban))
+}
+
+// actionFunc handles an admin action. It returns the target and details for
+// the audit log, and the error (if any) that was reported to the caller.
+type actionFunc func(w http.ResponseWriter, r *http.Request) (target, detail string, err error)
+
+// authed requires a valid bearer token and writes an audit entry for every attempt.
+func (m *moderation) authed(action string, h actionFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := m.authenticate(r)
+		if !ok {
+			m.audit.WarnContext(r.Context(), "admin auth failed", "action", action, "remote", r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="pastey-admin"`)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), actorKey{}, actor)
+		target, detail, err := h(w, r.WithContext(ctx))
+
+		attrs := []any{"actor", actor, "action", action, "target", target, "remote", r.RemoteAddr}
+		if detail != "" {
+			attrs = append(attrs, "detail", detail)
+		}
+		if err != nil {
+			attrs = append(attrs, "result", "error", "error", err)
+		} else {
+			attrs = append(attrs, "result", "ok")
+		}
+		m.audit.InfoContext(ctx, "admin action", attrs...)
+	})
+}
+
+// authenticate returns the operator name for the request's bearer token.
+func (m *moderation) authenticate(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	// Compare against every token so timing doesn't reveal which exist
+	actor := ""
+	for t, name := range m.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			actor = name
+		}
+	}
+	return actor, actor != ""
+}
+
+func (m *moderation) listRecent(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	limit := defaultRecentLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxRecentLimit {
+			err = errors.New("limit must be between 1 and 1000")
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return "", "", err
+		}
+		limit = n
+	}
+
+	metas, err := m.store.Recent(r.Context(), limit)
+	if err != nil {
+		writeStoreError(w, err)
+		return "", "", err
+	}
+	writeJSON(w, http.StatusOK, metas)
+	return "", "limit=" + strconv.Itoa(limit), nil
+}
+
+func (m *moderation) viewMeta(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	id := r.PathValue("id")
+	meta, err := m.store.Meta(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return id, "", err
+	}
+	writeJSON(w, http.StatusOK, meta)
+	return id, "", nil
+}
+
+func (m *moderation) viewContent(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	id := r.PathValue("id")
+	// Peeked so reviewing a burn-after-reading paste doesn't use it up
+	val, err := m.store.Peek(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return id, "", err
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(val.Body))
+	return id, "", nil
+}
+
+func (m *moderation) deletePaste(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	id := r.PathValue("id")
+	if err := m.store.Delete(r.Context(), id); err != nil {
+		writeStoreError(w, err)
+		return id, "", err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return id, "", nil
+}
+
+func (m *moderation) tombstone(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	id := r.PathValue("id")
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		err = errors.New("body must be JSON with a non-empty reason")
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return id, "", err
+	}
+
+	if err := m.store.Tombstone(r.Context(), id, req.Reason); err != nil {
+		writeStoreError(w, err)
+		return id, req.Reason, err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return id, req.Reason, nil
+}
+
+func (m *moderation) viewBan(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	ip, err := parseIP(w, r)
+	if err != nil {
+		return r.PathValue("ip"), "", err
+	}
+
+	ban, err := m.store.Banned(r.Context(), ip)
+	if err != nil {
+		writeStoreError(w, err)
+		return ip, "", err
+	}
+	if ban == nil {
+		writeJSONError(w, http.StatusNotFound, "not banned")
+		return ip, "", nil
+	}
+	writeJSON(w, http.StatusOK, ban)
+	return ip, "", nil
+}
+
+func (m *moderation) ban(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	ip, err := parseIP(w, r)
+	if err != nil {
+		return r.PathValue("ip"), "", err
+	}
+
+	var req struct {
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"`
+	}
+	if err := decodeJSON(r, &req); err != nil || strings.TrimSpace(req.Reason) == "" {
+		err = errors.New("body must be JSON with a non-empty reason")
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return ip, "", err
+	}
+
+	var ttl time.Duration
+	if req.Duration != "" {
+		ttl, err = time.ParseDuration(req.Duration)
+		if err != nil || ttl <= 0 {
+			err = errors.New("duration must be a positive Go duration such as 24h")
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return ip, req.Reason, err
+		}
+	}
+
+	detail := req.Reason + " (permanent)"
+	if ttl > 0 {
+		detail = req.Reason + " (" + ttl.String() + ")"
+	}
+	if err := m.store.Ban(r.Context(), ip, req.Reason, ttl); err != nil {
+		writeStoreError(w, err)
+		return ip, detail, err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return ip, detail, nil
+}
+
+func (m *moderation) unban(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	ip, err := parseIP(w, r)
+	if err != nil {
+		return r.PathValue("ip"), "", err
+	}
+	if err := m.store.Unban(r.Context(), ip); err != nil {
+		writeStoreError(w, err)
+		return ip, "", err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return ip, "", nil
+}
+
+// parseIP validates and normalises the {ip} path value, writing a 400 if invalid.
+func parseIP(w http.ResponseWriter, r *http.Request) (string, error) {
+	addr, err := netip.ParseAddr(r.PathValue("ip"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid IP address")
+		return "", err
+	}
+	return addr.Unmap().String(), nil
+}
+
+func decodeJSON(r *http.Request, v any) error {
+	return json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestBody)).Decode(v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// writeStoreError maps store errors to responses.
+func writeStoreError(w http.ResponseWriter, err error) {
+	var gone *store.GoneError
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeJSONError(w, http.StatusNotFound, "not found")
+	case errors.As(err, &gone):
+		writeJSON(w, http.StatusGone, map[string]string{"error": "removed", "reason": gone.Reason})
+	default:
+		slog.Error("admin store operation failed", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "store error")
+	}
+}
@@ -0,0 +1,62 @@
+package httpserver
+
+import (
+	"net/http"
+
+	"github.com/tombowditch/pastey-serv/internal/ansi"
+	"github.com/tombowditch/pastey-serv/internal/paste"
+)
+
+// ansiView is how terminal escape sequences in a paste are shown, chosen with
+// the ansi query parameter.
+type ansiView string
+
+const (
+	// ansiSanitize keeps colors but removes sequences that could reprogram a
+	// terminal reading the paste. It is the default.
+	ansiSanitize ansiView = ""
+	ansiStrip    ansiView = "strip"
+	ansiHTML     ansiView = "html"
+	// ansiRaw serves the paste exactly as uploaded.
+	ansiRaw ansiView = "raw"
+)
+
+func parseANSIView(r *http.Request) (ansiView, error) {
+	switch v := ansiView(r.URL.Query().Get("ansi")); v {
+	case ansiSanitize, ansiStrip, ansiHTML, ansiRaw:
+		return v, nil
+	default:
+		return "", &paste.ValidationError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "invalid ansi " + string(v) + " (use strip, html or raw)",
+			Reason:     paste.ReasonBadOptions,
+		}
+	}
+}
+
+// render returns a paste's content in the view, and its content type.
+func (v ansiView) render(title, body string) (string, string) {
+	switch v {
+	case ansiStrip:
+		return ansi.Clean(body, ansi.Strip), "text/plain"
+	case ansiHTML:
+		return string(ansi.HTML(title, body)), "text/html; charset=utf-8"
+	case ansiRaw:
+		return body, "text/plain"
+	default:
+		return ansi.Clean(body, ansi.Sanitize), "text/plain"
+	}
+}
+
+// filter returns a Filter for streaming content in the view, or nil to pass
+// it through. Live pastes can't be rendered as HTML, so are sanitized instead.
+func (v ansiView) filter() *ansi.Filter {
+	switch v {
+	case ansiRaw:
+		return nil
+	case ansiStrip:
+		return ansi.NewFilter(ansi.Strip)
+	default:
+		return ansi.NewFilter(ansi.Sanitize)
+	}
+}
@@ -0,0 +1,50 @@
+package httpserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tombowditch/pastey-serv/internal/store"
+)
+
+// maxCacheAge bounds how long a client may reuse a paste without
+// revalidating, so pastes taken down by a moderator soon stop being shown.
+const maxCacheAge = 5 * time.Minute
+
+// servePaste writes a paste's content. Pastes never change, so they carry a
+// strong ETag and may be cached briefly by the client; conditional and Range
+// requests are answered by http.ServeContent.
+func servePaste(w http.ResponseWriter, r *http.Request, p store.Paste, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	if strings.HasPrefix(contentType, "text/html") {
+		// Rendered pastes are static; nothing in them should run or load
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	if p.Burn {
+		// This read deleted it, so there is nothing to revalidate or resume
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(p.Body))
+		return
+	}
+
+	sum := sha256.Sum256([]byte(p.Body))
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Cache-Control", cacheControl(p.ExpiresAt))
+	http.ServeContent(w, r, "", p.CreatedAt, strings.NewReader(p.Body))
+}
+
+// cacheControl lets a paste be cached by the client for up to maxCacheAge,
+// and no longer than it has left to live. Shared caches are left out, as
+// they could keep serving a paste after it is deleted or taken down.
+func cacheControl(expires time.Time) string {
+	age := min(time.Until(expires), maxCacheAge)
+	if age < time.Second {
+		return "no-cache"
+	}
+	return "private, max-age=" + strconv.Itoa(int(age.Seconds()))
+}
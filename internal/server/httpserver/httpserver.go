@@ -1,35 +1,45 @@
 package httpserver
 
 import (
+	"errors"
 	"io"
-	"log/slog"
-	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
-	rate "github.com/wallstreetcn/rate/redis"
+	"go.opentelemetry.io/otel/attribute"
 
+	"github.com/tombowditch/pastey-serv/internal/clientip"
 	"github.com/tombowditch/pastey-serv/internal/config"
 	"github.com/tombowditch/pastey-serv/internal/paste"
-	"github.com/tombowditch/pastey-serv/internal/store"
-	"github.com/tombowditch/pastey-serv/internal/util/randutil"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
 )
 
 // Server holds dependencies for HTTP handlers.
 type Server struct {
-	store store.Store
+	pastes  *paste.Service
+	proxies *clientip.Resolver
 }
 
-// NewHandler creates an HTTP handler with all routes configured.
-func NewHandler(s store.Store) http.Handler {
-	srv := &Server{store: s}
+// NewHandler creates an HTTP handler with all routes configured. Client
+// addresses are taken from forwarding headers only for requests from proxies.
+func NewHandler(pastes *paste.Service, proxies *clientip.Resolver) http.Handler {
+	srv := &Server{
+		pastes:  pastes,
+		proxies: proxies,
+	}
 
 	r := httprouter.New()
-	r.GET("/", srv.indexPage)
-	r.GET("/:identifier", srv.getIdentifier)
-	r.POST("/create", srv.createPaste)
+	r.GET("/", srv.wrap("/", srv.indexPage))
+	r.GET("/:identifier", srv.wrap("/:identifier", srv.getIdentifier))
+	r.GET("/:identifier/:format", srv.wrap("/:identifier/:format", srv.viewPaste))
+	r.POST("/create", srv.wrap("/create", srv.createPaste))
+	r.OPTIONS("/uploads", srv.wrap("/uploads", srv.uploadOptions))
+	r.POST("/uploads", srv.wrap("/uploads", srv.createUpload))
+	r.HEAD("/uploads/:id", srv.wrap("/uploads/:id", srv.uploadStatus))
+	r.PATCH("/uploads/:id", srv.wrap("/uploads/:id", srv.patchUpload))
+	r.DELETE("/uploads/:id", srv.wrap("/uploads/:id", srv.deleteUpload))
 
 	return r
 }
@@ -38,9 +48,42 @@ func (s *Server) indexPage(w http.ResponseWriter, r *http.Request, _ httprouter.
 	w.Header().Set("Content-Type", "text/plain")
 	w.Write([]byte(`ig.lc - commandline pastebin
 
-pipe to 'nc ig.lc 9999'
+pipe to 'nc -N ig.lc 9999'
 
+- -N (or -q0) ends the upload as soon as input ends; without it pastey
+  waits for 2 seconds of silence
 - pastes are stored for 72 hours, after which they are automatically deleted
+- read a paste back with 'echo GET yourpaste | nc -N ig.lc 9999'
+- link to part of a paste with ?lines=120-180, ?grep=ERROR, ?head=50 or
+  ?tail=200, applied in that order and combinable (not while a paste is live)
+- terminal escape sequences other than colors are removed; add ?ansi=strip
+  for plain text, ?ansi=html to see colors in a browser, or ?ansi=raw for
+  the paste exactly as uploaded
+- see a paste rendered in a browser at /yourpaste/view: markdown as a page,
+  json as a collapsible tree, yaml and toml checked for errors, csv as a
+  table. the type is detected, or name it: /yourpaste/md, /json, /yaml,
+  /toml or /csv
+
+options
+=======
+
+start the paste with a line of options, which is removed before storing:
+
+  #pastey secure ttl=1h burn
+
+- secure: use a long, unguessable link
+- ttl=<duration>: delete sooner, e.g. ttl=30m (1m to 72h)
+- burn: delete after the first read
+- live: get the link immediately; viewers see output as it arrives until
+  the connection is half-closed (up to 1 hour)
+- eof: keep reading until the connection is half-closed, for slow commands
+  (up to 5 minutes without output, 1 hour in total)
+- length=<bytes>: exactly this many bytes follow, no need to close or wait
+
+if your content itself starts with #pastey, add another #: ##pastey is
+stored as #pastey. over http use /create?secure=true&ttl=1h&burn=true,
+or /create?live=true with a streamed (chunked) body. files too large for
+one request can be sent with any tus (https://tus.io) client to /uploads
 
 example
 =======
@@ -51,53 +94,95 @@ https://ig.lc/yourpaste
 ~> cat /etc/nginx/nginx.conf | nc ig.lc 9999
 https://ig.lc/yourpaste
 
+~> (echo "#pastey secure ttl=10m"; cat id_rsa.pub) | nc -N ig.lc 9999
+https://ig.lc/yourlongsecurepaste
+
+~> (echo "#pastey eof"; make 2>&1) | nc -N ig.lc 9999
+https://ig.lc/yourpaste
+
+~> (echo "#pastey live"; make 2>&1) | nc -N ig.lc 9999
+https://ig.lc/yourpaste
+(curl -N https://ig.lc/yourpaste to follow along)
+
+~> echo "GET yourpaste" | nc -N ig.lc 9999
+hello
+
 ~> cat 100mb.bin | nc ig.lc 9999
 too much data`))
 }
 
 func (s *Server) getIdentifier(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// Rate limit: 1 request per second per IP
-	cip := getClientIP(r)
-	limiter := rate.NewLimiter(rate.Every(time.Second), 1, "pastey_http_rl_"+cip)
-	if !limiter.Allow() {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusTooManyRequests)
-		w.Write([]byte("rate limit exceeded (1 request per second)"))
-		return
-	}
-
 	identifier := ps.ByName("identifier")
+	setPasteID(r, identifier)
 
-	val, err := s.store.Get(identifier)
+	// Checked first so a mistyped option doesn't use up a burn-after-reading paste
+	filter, err := paste.ParseLineFilter(r.URL.Query())
 	if err != nil {
-		if err != store.ErrNotFound {
-			slog.Error("store get failed", "error", err, "identifier", identifier)
+		writeError(w, err)
+		return
+	}
+	view, err := parseANSIView(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	val, err := s.pastes.Get(r.Context(), paste.GetRequest{
+		ID:       identifier,
+		ClientIP: getClientIP(r),
+		Channel:  paste.ChannelHTTP,
+	})
+	if errors.Is(err, paste.ErrLive) {
+		if filter.Active() {
+			writeError(w, &paste.ValidationError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "lines, grep, head and tail aren't available while a paste is live, try again once it finishes",
+				Reason:     paste.ReasonBadOptions,
+			})
+			return
 		}
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusNotFound)
-		w.Write([]byte("not found or expired"))
+		s.followPaste(w, r, identifier, view.filter())
+		return
+	}
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte(val))
+	if filter.Active() {
+		var b strings.Builder
+		filter.Apply(&b, val.Body)
+		val.Body = b.String()
+	}
+	var contentType string
+	val.Body, contentType = view.render(identifier, val.Body)
+	servePaste(w, r, val, contentType)
 }
 
 func (s *Server) createPaste(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	defer r.Body.Close()
 
-	// Rate limit: 1 paste per 5 seconds per IP
 	cip := getClientIP(r)
-	limiter := rate.NewLimiter(rate.Every(time.Second*5), 1, "pastey_http_create_rl_"+cip)
-	if !limiter.Allow() {
-		w.Header().Set("Content-Type", "text/plain")
-		w.WriteHeader(http.StatusTooManyRequests)
-		w.Write([]byte("rate limit exceeded (1 paste per 5 seconds)"))
+	if err := s.pastes.AllowCreate(r.Context(), cip); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	req, err := createRequest(r, cip)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if r.URL.Query().Get("live") == "true" {
+		s.createLivePaste(w, r, req)
 		return
 	}
 
 	// Read body (max 5MB + 1 byte to detect overflow)
+	_, span := tracing.Start(r.Context(), "http.ReadBody")
 	body, err := io.ReadAll(io.LimitReader(r.Body, int64(config.MaxPayloadSize)+1))
+	span.SetAttributes(attribute.Int("http.request.body.size", len(body)))
+	tracing.End(span, err)
 	if err != nil {
 		w.Header().Set("Content-Type", "text/plain")
 		w.WriteHeader(http.StatusBadRequest)
@@ -105,73 +190,50 @@ func (s *Server) createPaste(w http.ResponseWriter, r *http.Request, _ httproute
 		return
 	}
 
-	// Validate paste content
-	if err := paste.Validate(body); err != nil {
-		w.Header().Set("Content-Type", "text/plain")
-		if ve, ok := err.(*paste.ValidationError); ok {
-			w.WriteHeader(ve.StatusCode)
-			w.Write([]byte(ve.Message))
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
-		}
+	req.Body = body
+	res, err := s.pastes.Create(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
+	setPasteID(r, res.ID)
 
-	// Determine identifier length
-	idLength := paste.IDLength(r.URL.Query().Get("secure") == "true")
-
-	// Generate unique identifier and store atomically
-	var identifier string
-	for tried := 0; tried < 10; tried++ {
-		identifier = randutil.RandString(idLength)
-		ok, err := s.store.Create(identifier, body)
-		if err != nil {
-			slog.Error("store create failed", "error", err)
-			w.Header().Set("Content-Type", "text/plain")
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("error"))
-			return
-		}
-		if ok {
-			slog.Info("created paste via HTTP POST", "identifier", identifier, "remote", cip)
-			w.Header().Set("Content-Type", "text/plain")
-			w.WriteHeader(http.StatusCreated)
-			w.Write([]byte(config.BaseURL + identifier + "\n"))
-			return
-		}
-		// Collision, try again
-	}
-
-	// Failed to generate unique identifier after retries
+	writeSecrets(w, res)
 	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(http.StatusInternalServerError)
-	w.Write([]byte("could not generate identifier"))
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(res.URL + "\n"))
 }
 
-// getClientIP extracts the real client IP.
-// Only trusts X-Forwarded-For and X-Real-IP headers if config.TrustProxy() is true.
-func getClientIP(r *http.Request) string {
-	if config.TrustProxy() {
-		// Check X-Forwarded-For header (first IP is the original client)
-		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-			// X-Forwarded-For can be comma-separated list: client, proxy1, proxy2
-			if idx := strings.Index(xff, ","); idx != -1 {
-				return strings.TrimSpace(xff[:idx])
-			}
-			return strings.TrimSpace(xff)
-		}
-
-		// Check X-Real-IP header
-		if xri := r.Header.Get("X-Real-IP"); xri != "" {
-			return strings.TrimSpace(xri)
+// createRequest reads the paste options shared by /create and /uploads from
+// the query string.
+func createRequest(r *http.Request, clientIP string) (paste.CreateRequest, error) {
+	var ttl time.Duration
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		var err error
+		if ttl, err = paste.ParseTTL(v); err != nil {
+			return paste.CreateRequest{}, err
 		}
 	}
+	return paste.CreateRequest{
+		Secure:   r.URL.Query().Get("secure") == "true",
+		TTL:      ttl,
+		Burn:     r.URL.Query().Get("burn") == "true",
+		ClientIP: clientIP,
+		Channel:  paste.ChannelHTTP,
+	}, nil
+}
 
-	// Use RemoteAddr directly
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+// writeSecrets warns the client about secrets found in a new paste.
+func writeSecrets(w http.ResponseWriter, res paste.CreateResult) {
+	if len(res.Secrets) > 0 {
+		w.Header().Set("X-Pastey-Secrets", strings.Join(res.Secrets, ","))
+		w.Header().Set("X-Pastey-Notice", res.Notice())
 	}
-	return ip
+}
+
+// writeError writes an error returned by paste.Service as a plain text response.
+func writeError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(paste.StatusCode(err))
+	w.Write([]byte(paste.Message(err)))
 }
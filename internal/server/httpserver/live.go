@@ -0,0 +1,144 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tombowditch/pastey-serv/internal/ansi"
+	"github.com/tombowditch/pastey-serv/internal/paste"
+)
+
+// liveWriteTimeout bounds each write of new content to a live paste's viewer,
+// replacing the server's WriteTimeout for the otherwise unbounded response.
+const liveWriteTimeout = 30 * time.Second
+
+// createLivePaste handles /create?live=true. The response starts with the
+// paste's URL, then the request body is shown to viewers as it arrives until
+// it ends, so clients should stream it, e.g. with chunked encoding:
+//
+//	make 2>&1 | curl -sN -T - -X POST 'https://ig.lc/create?live=true'
+//
+// Errors after the URL has been sent are reported on the following line.
+func (s *Server) createLivePaste(w http.ResponseWriter, r *http.Request, req paste.CreateRequest) {
+	ctx := r.Context()
+	live, err := s.pastes.StartLive(ctx, req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	setPasteID(r, live.ID)
+
+	rc := http.NewResponseController(w)
+	// HTTP/1 otherwise stops reading the body once the response has started.
+	// HTTP/2 doesn't support (or need) this.
+	rc.EnableFullDuplex()
+	rc.SetReadDeadline(live.Expires)
+	rc.SetWriteDeadline(live.Expires)
+
+	// Answer "Expect: 100-continue" before the response, which would
+	// otherwise make clients wait for it or give up on sending the body
+	r.Body.Read(nil)
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(live.URL + "\n"))
+	rc.Flush()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Body.Read(buf)
+		if n > 0 {
+			if err := live.Append(ctx, buf[:n]); err != nil {
+				live.Abort(ctx)
+				w.Write([]byte(paste.Message(err) + "\n"))
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			live.Abort(ctx)
+			w.Write([]byte("error reading body\n"))
+			return
+		}
+	}
+
+	res, err := live.Finish(ctx)
+	if err != nil {
+		w.Write([]byte(paste.Message(err) + "\n"))
+		return
+	}
+	if notice := res.Notice(); notice != "" {
+		w.Write([]byte(notice + "\n"))
+	}
+}
+
+// followPaste streams a live paste to the client until its upload ends. Clients
+// accepting text/event-stream get Server-Sent Events: "append" events whose
+// data is the new content as a JSON string, then an "end" event. Others get
+// the content as plain text, with the response ending when the upload does.
+// Unless filter is nil, content passes through it first.
+func (s *Server) followPaste(w http.ResponseWriter, r *http.Request, id string, filter *ansi.Filter) {
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "text/plain")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	// Content split mid-character is held back so events stay valid UTF-8
+	var partial []byte
+	err := s.pastes.Follow(r.Context(), id, func(data []byte) error {
+		if filter != nil {
+			if data = filter.Write(nil, data); len(data) == 0 {
+				return nil
+			}
+		}
+		if sse {
+			data, partial = completeRunes(append(partial, data...))
+			if len(data) == 0 {
+				return nil
+			}
+			data = sseEvent("append", data)
+		}
+		rc.SetWriteDeadline(time.Now().Add(liveWriteTimeout))
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	if err == nil && sse {
+		w.Write(sseEvent("end", partial))
+		rc.Flush()
+	}
+}
+
+// sseEvent formats data as a Server-Sent Event with a JSON string payload.
+func sseEvent(event string, data []byte) []byte {
+	payload, _ := json.Marshal(string(data))
+	return []byte("event: " + event + "\ndata: " + string(payload) + "\n\n")
+}
+
+// completeRunes splits off an incomplete UTF-8 sequence at the end of data.
+func completeRunes(data []byte) (complete, rest []byte) {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i], data[i:]
+			}
+			break
+		}
+	}
+	return data, nil
+}
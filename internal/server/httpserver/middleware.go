@@ -0,0 +1,155 @@
+package httpserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tombowditch/pastey-serv/internal/logging"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
+	"github.com/tombowditch/pastey-serv/internal/util/randutil"
+)
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	requestIDLength    = 16
+	maxRequestIDLength = 64
+)
+
+// responseRecorder captures the status code and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush
+// and set deadlines.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// countingReader counts bytes read from the request body.
+type countingReader struct {
+	io.ReadCloser
+	bytes int
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.bytes += n
+	return n, err
+}
+
+// accessInfo carries the resolved client address to handlers and collects
+// details from them for the access log line.
+type accessInfo struct {
+	clientIP string
+	pasteID  string
+}
+
+type accessInfoKey struct{}
+
+// setPasteID records the paste a request operated on for the access log.
+func setPasteID(r *http.Request, id string) {
+	if info, ok := r.Context().Value(accessInfoKey{}).(*accessInfo); ok {
+		info.pasteID = id
+	}
+}
+
+// getClientIP returns the client address resolved by wrap.
+func getClientIP(r *http.Request) string {
+	if info, ok := r.Context().Value(accessInfoKey{}).(*accessInfo); ok {
+		return info.clientIP
+	}
+	return r.RemoteAddr
+}
+
+// wrap instruments h for route: it assigns a request ID (reusing a valid
+// incoming X-Request-ID) and echoes it, starts a server span continuing any
+// propagated trace, and emits one access log line when the handler returns.
+func (s *Server) wrap(route string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		start := time.Now()
+
+		requestID := r.Header.Get(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = randutil.RandString(requestIDLength)
+		}
+		w.Header().Set(requestIDHeader, requestID)
+
+		clientIP := s.proxies.FromRequest(r)
+		info := &accessInfo{clientIP: clientIP}
+		ctx := logging.WithRequestID(r.Context(), requestID)
+		ctx = context.WithValue(ctx, accessInfoKey{}, info)
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracing.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("client.address", clientIP),
+				attribute.String("request.id", requestID),
+			),
+		)
+		defer span.End()
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(ctx), ps)
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+
+		slog.InfoContext(ctx, "http access",
+			"method", r.Method,
+			"route", route,
+			"path", r.URL.Path,
+			"status", rec.status,
+			"bytes_in", body.bytes,
+			"bytes_out", rec.bytes,
+			"duration", time.Since(start),
+			"remote", clientIP,
+			"paste_id", info.pasteID,
+		)
+	}
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to reuse.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/tombowditch/pastey-serv/internal/ansi"
+	"github.com/tombowditch/pastey-serv/internal/paste"
+	"github.com/tombowditch/pastey-serv/internal/render"
+)
+
+// viewPaste handles /:identifier/:format, showing a paste as an HTML page.
+// The format "view" is detected from the content; otherwise it is named, as
+// in /abc1234/json.
+func (s *Server) viewPaste(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	identifier := ps.ByName("identifier")
+	setPasteID(r, identifier)
+
+	var format render.Format
+	if name := ps.ByName("format"); name != "view" {
+		var ok bool
+		if format, ok = render.ParseFormat(name); !ok {
+			writeError(w, paste.ErrNotFound)
+			return
+		}
+	}
+
+	val, err := s.pastes.Get(r.Context(), paste.GetRequest{
+		ID:       identifier,
+		ClientIP: getClientIP(r),
+		Channel:  paste.ChannelHTTP,
+	})
+	if errors.Is(err, paste.ErrLive) {
+		// Live pastes can only be followed as they arrive
+		http.Redirect(w, r, "/"+identifier, http.StatusFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if len(val.Body) > render.MaxSize {
+		// Too large to render, but it may have been burnt by reading it, so
+		// it is shown as at /:identifier rather than lost
+		var contentType string
+		val.Body, contentType = ansiSanitize.render(identifier, val.Body)
+		servePaste(w, r, val, contentType)
+		return
+	}
+
+	body := ansi.Clean(val.Body, ansi.Strip)
+	if format == "" {
+		format = render.Detect(body)
+	}
+	val.Body = string(render.Page(identifier, "/"+identifier, format, body))
+	servePaste(w, r, val, "text/html; charset=utf-8")
+}
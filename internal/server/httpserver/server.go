@@ -0,0 +1,47 @@
+package httpserver
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ServerOptions configures the limits and protocols of an http.Server.
+type ServerOptions struct {
+	// ReadHeaderTimeout bounds reading request headers, defeating slowloris.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading the entire request, including the body.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time from the end of the request headers to the end of the response.
+	WriteTimeout time.Duration
+	// IdleTimeout bounds how long keep-alive connections wait for the next request.
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+	// H2C serves cleartext HTTP/2 (prior knowledge or Upgrade) alongside
+	// HTTP/1.1, for proxies that speak HTTP/2 to their backends. TLS
+	// listeners negotiate HTTP/2 with ALPN regardless.
+	H2C bool
+}
+
+// NewServer creates an http.Server for h on addr with the given options.
+func NewServer(addr string, h http.Handler, opts ServerOptions) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		ReadTimeout:       opts.ReadTimeout,
+		WriteTimeout:      opts.WriteTimeout,
+		IdleTimeout:       opts.IdleTimeout,
+		MaxHeaderBytes:    opts.MaxHeaderBytes,
+	}
+	if opts.H2C {
+		h2 := &http2.Server{IdleTimeout: opts.IdleTimeout}
+		// Registers h2's graceful shutdown with srv, which otherwise can't see
+		// the connections h2c takes over
+		http2.ConfigureServer(srv, h2)
+		srv.Handler = h2c.NewHandler(h, h2)
+	}
+	return srv
+}
@@ -0,0 +1,181 @@
+package httpserver
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/tombowditch/pastey-serv/internal/config"
+	"github.com/tombowditch/pastey-serv/internal/paste"
+)
+
+// Resumable uploads follow the core tus 1.0.0 protocol (https://tus.io) with
+// the creation and termination extensions:
+//
+//	POST   /uploads       Upload-Length: N, create options as for /create  -> 201, Location
+//	HEAD   /uploads/:id   -> Upload-Offset, Upload-Length
+//	PATCH  /uploads/:id   Upload-Offset: N, application/offset+octet-stream -> 204, Upload-Offset
+//	DELETE /uploads/:id   -> 204
+//
+// The response to the PATCH completing an upload, and later HEAD requests,
+// carry the paste's URL in X-Pastey-URL.
+const (
+	tusVersion     = "1.0.0"
+	tusContentType = "application/offset+octet-stream"
+	pasteURLHeader = "X-Pastey-URL"
+
+	// uploadBufferSize is how much of a PATCH body is buffered before it is
+	// stored, so data received before a connection drops isn't lost.
+	uploadBufferSize = 1 << 20
+)
+
+// tusHeaders sets the headers every tus response carries and rejects clients
+// speaking another protocol version.
+func tusHeaders(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if v := r.Header.Get("Tus-Resumable"); v != "" && v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func (s *Server) uploadOptions(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination")
+	if max := s.pastes.UploadMaxSize(); max > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.Itoa(max))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) createUpload(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !tusHeaders(w, r) {
+		return
+	}
+
+	cip := getClientIP(r)
+	if err := s.pastes.AllowCreate(r.Context(), cip); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		writeError(w, &paste.ValidationError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Upload-Length header required",
+			Reason:     paste.ReasonBadOptions,
+		})
+		return
+	}
+	req, err := createRequest(r, cip)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	u, err := s.pastes.StartUpload(r.Context(), req, length)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Location", config.BaseURL+"uploads/"+u.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) uploadStatus(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !tusHeaders(w, r) {
+		return
+	}
+
+	u, err := s.pastes.GetUpload(r.Context(), ps.ByName("id"))
+	if err != nil {
+		w.WriteHeader(paste.StatusCode(err))
+		return
+	}
+	writeUploadHeaders(w, u)
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) patchUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	defer r.Body.Close()
+	if !tusHeaders(w, r) {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != tusContentType {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		w.Write([]byte("Content-Type must be " + tusContentType))
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeError(w, &paste.ValidationError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Upload-Offset header required",
+			Reason:     paste.ReasonBadOptions,
+		})
+		return
+	}
+
+	id := ps.ByName("id")
+	buf := make([]byte, uploadBufferSize)
+	var u paste.Upload
+	for {
+		n, readErr := io.ReadFull(r.Body, buf)
+		// Always append once, so an empty PATCH still reports the offset
+		if n > 0 || u.ID == "" {
+			u, err = s.pastes.AppendUpload(r.Context(), id, offset, buf[:n], paste.ChannelHTTP)
+			if err != nil {
+				if errors.Is(err, paste.ErrOffsetMismatch) {
+					writeUploadHeaders(w, u)
+				}
+				writeError(w, err)
+				return
+			}
+			offset = u.Offset
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			// The client went away; what arrived is kept for it to resume from
+			return
+		}
+	}
+
+	if u.Result != nil {
+		setPasteID(r, u.Result.ID)
+		writeSecrets(w, *u.Result)
+	}
+	writeUploadHeaders(w, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !tusHeaders(w, r) {
+		return
+	}
+
+	if err := s.pastes.DeleteUpload(r.Context(), ps.ByName("id")); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeUploadHeaders reports an upload's progress, and its paste once complete.
+func writeUploadHeaders(w http.ResponseWriter, u paste.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	if u.Result != nil {
+		w.Header().Set(pasteURLHeader, u.Result.URL)
+	}
+}
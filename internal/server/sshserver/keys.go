@@ -0,0 +1,82 @@
+package sshserver
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadHostKey reads a PEM private host key from path. If the file doesn't
+// exist a new ed25519 key is generated and saved there, so clients see the
+// same host key after restarts.
+func LoadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return generateHostKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading host key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing host key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+func generateHostKey(path string) (ssh.Signer, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		return nil, err
+	}
+	// O_EXCL so replicas starting together don't overwrite each other's key
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("saving host key: %w", err)
+	}
+	if err := pem.Encode(f, block); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("saving host key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("saving host key: %w", err)
+	}
+	return ssh.NewSignerFromKey(priv)
+}
+
+// LoadAuthorizedKeys parses an OpenSSH authorized_keys file into a map from
+// each key to its owner: the key's comment, or its fingerprint if it has none.
+func LoadAuthorizedKeys(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading authorized keys: %w", err)
+	}
+
+	keys := make(map[string]string)
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, comment, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("parsing authorized keys %s line %d: %w", path, i+1, err)
+		}
+		if comment == "" {
+			comment = ssh.FingerprintSHA256(key)
+		}
+		keys[string(key.Marshal())] = comment
+	}
+	return keys, nil
+}
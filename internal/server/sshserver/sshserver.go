@@ -0,0 +1,412 @@
+// Package sshserver lets clients create and read pastes over SSH, for hosts
+// that can't reach the TCP paste port:
+//
+//	ssh -p 2222 ig.lc < file        # create a paste, prints its URL
+//	ssh -p 2222 ig.lc get <id>      # print a paste
+package sshserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/tombowditch/pastey-serv/internal/ansi"
+	"github.com/tombowditch/pastey-serv/internal/clientip"
+	"github.com/tombowditch/pastey-serv/internal/config"
+	"github.com/tombowditch/pastey-serv/internal/connlimit"
+	"github.com/tombowditch/pastey-serv/internal/logging"
+	"github.com/tombowditch/pastey-serv/internal/metrics"
+	"github.com/tombowditch/pastey-serv/internal/paste"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
+	"github.com/tombowditch/pastey-serv/internal/util/randutil"
+)
+
+const (
+	// requestIDLength is the length of the ID assigned to each connection for log correlation.
+	requestIDLength = 16
+	// handshakeTimeout bounds the SSH handshake, waiting for a session and
+	// waiting for its command.
+	handshakeTimeout = 10 * time.Second
+	// writeTimeout bounds each write of paste content to a session.
+	writeTimeout = 30 * time.Second
+	// ownerExtension carries the authenticated key's owner in ssh.Permissions.
+	ownerExtension = "pastey-owner"
+
+	usage = "usage: ssh <host> < file, or ssh <host> get <id>"
+)
+
+// Options configures the SSH server.
+type Options struct {
+	// HostKey identifies the server to clients; see LoadHostKey.
+	HostKey ssh.Signer
+	// AuthorizedKeys restricts clients to these public keys (see
+	// LoadAuthorizedKeys) and records each key's owner on its pastes.
+	// Empty allows anyone to connect without authenticating.
+	AuthorizedKeys map[string]string
+	// MaxReadTime bounds the total time spent receiving a paste. Zero means unlimited.
+	MaxReadTime time.Duration
+	// MaxConns caps concurrent connections, and MaxConnsPerIP those from one
+	// client IP, as on the TCP port; connections beyond them are closed
+	// before the handshake. Zero means unlimited.
+	MaxConns      int
+	MaxConnsPerIP int
+}
+
+// Server holds dependencies for the SSH server.
+type Server struct {
+	pastes    *paste.Service
+	opts      Options
+	config    *ssh.ServerConfig
+	listening atomic.Int32
+	conns     sync.WaitGroup
+	active    atomic.Int64
+	perIP     *connlimit.PerIP
+}
+
+// New creates a new SSH server using the given paste service.
+func New(pastes *paste.Service, opts Options) *Server {
+	cfg := &ssh.ServerConfig{ServerVersion: "SSH-2.0-pastey"}
+	if len(opts.AuthorizedKeys) == 0 {
+		cfg.NoClientAuth = true
+	} else {
+		cfg.PublicKeyCallback = func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			owner, ok := opts.AuthorizedKeys[string(key.Marshal())]
+			if !ok {
+				return nil, errors.New("unknown public key")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{ownerExtension: owner}}, nil
+		}
+	}
+	cfg.AddHostKey(opts.HostKey)
+	return &Server{pastes: pastes, opts: opts, config: cfg, perIP: connlimit.NewPerIP(opts.MaxConnsPerIP)}
+}
+
+// Serve starts listening on the given address and handles connections.
+// Like the TCP server's Serve, it blocks until the listener fails or ctx is
+// cancelled, then waits for in-flight connections to finish.
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	defer s.conns.Wait()
+
+	s.listening.Add(1)
+	defer s.listening.Add(-1)
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	slog.Info("ssh server listening", "addr", addr, "authorized_keys", len(s.opts.AuthorizedKeys))
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			slog.Error("error accepting connection", "error", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		if s.opts.MaxConns > 0 && s.active.Load() >= int64(s.opts.MaxConns) {
+			metrics.SSHRejectedConnections.WithLabelValues("max_conns").Inc()
+			conn.Close()
+			continue
+		}
+		s.active.Add(1)
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			defer s.active.Add(-1)
+			connCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+			defer cancel()
+			s.handleConn(connCtx, conn)
+		}()
+	}
+}
+
+// Check reports an error unless the listener is accepting connections.
+// It is intended as a readiness check.
+func (s *Server) Check(ctx context.Context) error {
+	if s.listening.Load() == 0 {
+		return errors.New("ssh listener not accepting connections")
+	}
+	return nil
+}
+
+func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	cip := clientip.FromAddr(conn.RemoteAddr())
+	if !s.perIP.Acquire(cip) {
+		metrics.SSHRejectedConnections.WithLabelValues("max_conns_per_ip").Inc()
+		return
+	}
+	defer s.perIP.Release(cip)
+
+	// Clients that may neither read nor create aren't worth a handshake
+	if err := s.pastes.AllowConnect(ctx, cip); err != nil {
+		slog.DebugContext(ctx, "ssh connection refused", "error", err, "remote", cip)
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		slog.DebugContext(ctx, "ssh handshake failed", "error", err, "remote", cip)
+		return
+	}
+	defer sconn.Close()
+	conn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(reqs)
+
+	var owner string
+	if sconn.Permissions != nil {
+		owner = sconn.Permissions.Extensions[ownerExtension]
+	}
+
+	// Sessions have their own limits, so the deadline only covers the wait
+	// for each one to start
+	idle := time.AfterFunc(handshakeTimeout, func() { sconn.Close() })
+	defer idle.Stop()
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		idle.Stop()
+		s.handleSession(ctx, conn, ch, chReqs, &session{clientIP: cip, user: sconn.User(), owner: owner})
+		idle.Reset(handshakeTimeout)
+	}
+}
+
+// session describes one command run over an SSH connection, for the access log.
+type session struct {
+	start    time.Time
+	clientIP string
+	user     string
+	owner    string
+	status   int
+	pasteID  string
+	bytesIn  int
+	bytesOut int
+}
+
+func (s *Server) handleSession(ctx context.Context, conn net.Conn, ch ssh.Channel, reqs <-chan *ssh.Request, sess *session) {
+	defer ch.Close()
+
+	requestID := randutil.RandString(requestIDLength)
+	ctx = logging.WithRequestID(ctx, requestID)
+	ctx, span := tracing.Start(ctx, "ssh.Session",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("client.address", sess.clientIP),
+			attribute.String("request.id", requestID),
+		),
+	)
+	defer span.End()
+
+	command, ok := waitCommand(reqs)
+	if !ok {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	sess.start = time.Now()
+	var code uint32
+	if id, ok := strings.CutPrefix(command, "get "); ok {
+		code = s.get(ctx, conn, ch, strings.TrimPrefix(strings.TrimSpace(id), config.BaseURL), sess)
+	} else if command == "" {
+		code = s.create(ctx, conn, ch, sess)
+	} else {
+		sess.status = http.StatusBadRequest
+		io.WriteString(ch.Stderr(), usage+"\n")
+		code = 2
+	}
+
+	slog.InfoContext(ctx, "ssh access",
+		"status", sess.status,
+		"bytes_in", sess.bytesIn,
+		"bytes_out", sess.bytesOut,
+		"duration", time.Since(sess.start),
+		"remote", sess.clientIP,
+		"user", sess.user,
+		"owner", sess.owner,
+		"paste_id", sess.pasteID,
+	)
+	ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{code}))
+}
+
+// waitCommand waits for the session's shell or exec request, returning the
+// command, which is empty for a shell. Terminals are refused, so interactive
+// clients are told no PTY is available but can still type a paste.
+func waitCommand(reqs <-chan *ssh.Request) (string, bool) {
+	timeout := time.NewTimer(handshakeTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-timeout.C:
+			return "", false
+		case req, ok := <-reqs:
+			if !ok {
+				return "", false
+			}
+			switch req.Type {
+			case "shell":
+				req.Reply(true, nil)
+				return "", true
+			case "exec":
+				var payload struct{ Command string }
+				if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+					req.Reply(false, nil)
+					return "", false
+				}
+				req.Reply(true, nil)
+				return strings.TrimSpace(payload.Command), true
+			default:
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// create reads a paste from the session's input until the client closes it,
+// and prints the URL. The input may start with a "#pastey" directive line as
+// on the TCP port.
+func (s *Server) create(ctx context.Context, conn net.Conn, ch ssh.Channel, sess *session) uint32 {
+	if err := s.pastes.AllowCreate(ctx, sess.clientIP); err != nil {
+		return fail(ch, sess, err)
+	}
+
+	// ssh.Channel has no read deadline, so cut the whole connection off
+	var timedOut atomic.Bool
+	if s.opts.MaxReadTime > 0 {
+		timer := time.AfterFunc(s.opts.MaxReadTime, func() {
+			timedOut.Store(true)
+			conn.Close()
+		})
+		defer timer.Stop()
+	}
+
+	_, readSpan := tracing.Start(ctx, "ssh.Read")
+	data, err := io.ReadAll(io.LimitReader(ch, int64(config.MaxPayloadSize)+1))
+	sess.bytesIn = len(data)
+	readSpan.SetAttributes(attribute.Int("ssh.bytes_read", len(data)))
+	tracing.End(readSpan, err)
+	if timedOut.Load() {
+		sess.status = http.StatusRequestTimeout
+		return 1
+	}
+	if err != nil {
+		sess.status = http.StatusBadRequest
+		return 1
+	}
+
+	directive, body, err := paste.ParseDirective(data)
+	if err != nil {
+		return fail(ch, sess, err)
+	}
+	if directive.Live {
+		sess.status = http.StatusBadRequest
+		io.WriteString(ch.Stderr(), "live pastes aren't supported over ssh\n")
+		return 1
+	}
+
+	res, err := s.pastes.Create(ctx, paste.CreateRequest{
+		Body:     body,
+		Secure:   directive.Secure,
+		TTL:      directive.TTL,
+		Burn:     directive.Burn,
+		ClientIP: sess.clientIP,
+		Channel:  paste.ChannelSSH,
+		Owner:    sess.owner,
+	})
+	if err != nil {
+		return fail(ch, sess, err)
+	}
+	sess.status = http.StatusCreated
+	sess.pasteID = res.ID
+
+	n, _ := io.WriteString(ch, res.URL+"\n")
+	sess.bytesOut += n
+	if notice := res.Notice(); notice != "" {
+		io.WriteString(ch.Stderr(), notice+"\n")
+	}
+	return 0
+}
+
+// get prints a paste, following live pastes until their upload ends.
+func (s *Server) get(ctx context.Context, conn net.Conn, ch ssh.Channel, id string, sess *session) uint32 {
+	sess.pasteID = id
+	val, err := s.pastes.Get(ctx, paste.GetRequest{
+		ID:       id,
+		ClientIP: sess.clientIP,
+		Channel:  paste.ChannelSSH,
+	})
+	// ssh.Channel has no write deadline, so a client that stops reading is
+	// cut off along with the whole connection
+	timer := time.AfterFunc(writeTimeout, func() { conn.Close() })
+	timer.Stop()
+	write := func(data []byte) (int, error) {
+		timer.Reset(writeTimeout)
+		defer timer.Stop()
+		return ch.Write(data)
+	}
+
+	// Readers are terminals, so only colors are passed through
+	if errors.Is(err, paste.ErrLive) {
+		filter := ansi.NewFilter(ansi.Sanitize)
+		err = s.pastes.Follow(ctx, id, func(data []byte) error {
+			n, err := write(filter.Write(nil, data))
+			sess.bytesOut += n
+			return err
+		})
+		if err != nil {
+			sess.status = http.StatusInternalServerError
+			return 1
+		}
+		sess.status = http.StatusOK
+		return 0
+	}
+	if err != nil {
+		return fail(ch, sess, err)
+	}
+
+	sess.status = http.StatusOK
+	n, _ := write([]byte(ansi.Clean(val.Body, ansi.Sanitize)))
+	sess.bytesOut += n
+	return 0
+}
+
+// fail writes an error returned by paste.Service to the session's stderr.
+func fail(ch ssh.Channel, sess *session, err error) uint32 {
+	sess.status = paste.StatusCode(err)
+	io.WriteString(ch.Stderr(), paste.Message(err)+"\n")
+	return 1
+}
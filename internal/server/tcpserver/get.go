@@ -0,0 +1,83 @@
+package tcpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tombowditch/pastey-serv/internal/ansi"
+	"github.com/tombowditch/pastey-serv/internal/config"
+	"github.com/tombowditch/pastey-serv/internal/paste"
+)
+
+// getRequest matches a connection whose whole input is one "GET <id>" line,
+// which reads a paste back instead of creating one. The ID may be given as
+// the paste's full URL. Content that is exactly such a line can be uploaded
+// by starting it with an empty "#pastey" directive line.
+var getRequest = regexp.MustCompile(`^GET (\S+)\r?\n?$`)
+
+var validID = regexp.MustCompile(`^[0-9A-Za-z]{1,64}$`)
+
+const (
+	// writeTimeout bounds each write of paste content to a reader, so one
+	// that stops reading doesn't hold its connection slot.
+	writeTimeout = 30 * time.Second
+	// writeChunkSize is how much of a stored paste is written per deadline.
+	writeChunkSize = 32 * 1024
+)
+
+// parseGet returns the requested paste ID if head is a read request.
+func parseGet(head []byte) (string, bool) {
+	m := getRequest.FindSubmatch(head)
+	if m == nil {
+		return "", false
+	}
+	id := strings.TrimPrefix(string(m[1]), config.BaseURL)
+	if !validID.MatchString(id) {
+		return "", false
+	}
+	return id, true
+}
+
+// servePaste writes a paste's content to the connection, sharing the HTTP
+// server's read rate limit and not-found handling. Live pastes are followed
+// until their upload ends.
+func (s *Server) servePaste(ctx context.Context, conn *countingConn, id, clientIP string, access *accessLog) {
+	access.status = http.StatusOK
+	access.pasteID = id
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	val, err := s.pastes.Get(ctx, paste.GetRequest{
+		ID:       id,
+		ClientIP: clientIP,
+		Channel:  paste.ChannelTCP,
+	})
+	if errors.Is(err, paste.ErrLive) {
+		s.followPaste(ctx, conn, id)
+		return
+	}
+	if err != nil {
+		access.status = paste.StatusCode(err)
+		writeError(conn, err)
+		return
+	}
+
+	// Readers are terminals, so only colors are passed through
+	body := []byte(ansi.Clean(val.Body, ansi.Sanitize))
+	for rest := body; len(rest) > 0; {
+		n := min(len(rest), writeChunkSize)
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := conn.Write(rest[:n]); err != nil {
+			return
+		}
+		rest = rest[n:]
+	}
+	// Keep the shell prompt on its own line
+	if !bytes.HasSuffix(body, []byte("\n")) {
+		conn.Write([]byte("\r\n"))
+	}
+}
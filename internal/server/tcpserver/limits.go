@@ -0,0 +1,21 @@
+package tcpserver
+
+import "time"
+
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+
+	// minRateGrace is how long a connection may read before MinReadRate is enforced,
+	// so clients aren't penalised for a slow start.
+	minRateGrace = 5 * time.Second
+)
+
+// acceptBackoff returns the delay before retrying a failed Accept, doubling
+// the previous delay up to maxAcceptBackoff.
+func acceptBackoff(prev time.Duration) time.Duration {
+	if prev == 0 {
+		return minAcceptBackoff
+	}
+	return min(prev*2, maxAcceptBackoff)
+}
@@ -0,0 +1,85 @@
+package tcpserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/tombowditch/pastey-serv/internal/ansi"
+	"github.com/tombowditch/pastey-serv/internal/paste"
+)
+
+// streamPaste uploads a live paste for a "#pastey live" directive. The client
+// gets the URL straight away, and everything it sends until it half-closes
+// the connection is shown to viewers as it arrives; pauses don't end the upload.
+func (s *Server) streamPaste(ctx context.Context, conn *countingConn, up *upload, d paste.Directive, access *accessLog) {
+	live, err := s.pastes.StartLive(ctx, paste.CreateRequest{
+		Secure:   d.Secure,
+		TTL:      d.TTL,
+		Burn:     d.Burn,
+		ClientIP: access.clientIP,
+		Channel:  paste.ChannelTCP,
+	})
+	if err != nil {
+		access.status = paste.StatusCode(err)
+		writeError(conn, err)
+		return
+	}
+	access.pasteID = live.ID
+	conn.Write([]byte(live.URL + "\r\n"))
+
+	up.limit = live.Expires
+	up.data = up.data[up.headerLen:]
+	for {
+		if len(up.data) > 0 {
+			if err := live.Append(ctx, up.data); err != nil {
+				live.Abort(ctx)
+				access.status = paste.StatusCode(err)
+				writeError(conn, err)
+				return
+			}
+			// Viewers read back from the store, so there's no need to keep it
+			up.data = up.data[:0]
+		}
+		if up.done {
+			break
+		}
+		if err := up.read(0, 0); err != nil {
+			live.Abort(ctx)
+			uploadFailed(ctx, conn, access, err)
+			return
+		}
+	}
+
+	res, err := live.Finish(ctx)
+	if err != nil {
+		access.status = paste.StatusCode(err)
+		writeError(conn, err)
+		return
+	}
+	if notice := res.Notice(); notice != "" {
+		conn.Write([]byte(notice + "\r\n"))
+	}
+}
+
+// followPaste writes a live paste's content to the connection as it arrives,
+// until its upload ends. As for stored pastes, only colors are passed through.
+func (s *Server) followPaste(ctx context.Context, conn *countingConn, id string) {
+	filter := ansi.NewFilter(ansi.Sanitize)
+	var last byte
+	err := s.pastes.Follow(ctx, id, func(data []byte) error {
+		if data = filter.Write(nil, data); len(data) == 0 {
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		last = data[len(data)-1]
+		_, err := conn.Write(data)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	// Keep the shell prompt on its own line
+	if last != '\n' {
+		conn.Write([]byte("\r\n"))
+	}
+}
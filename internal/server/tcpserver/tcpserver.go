@@ -1,124 +1,346 @@
 package tcpserver
 
 import (
-	"io"
+	"context"
+	"crypto/tls"
+	"errors"
 	"log/slog"
 	"net"
+	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	rate "github.com/wallstreetcn/rate/redis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/tombowditch/pastey-serv/internal/clientip"
 	"github.com/tombowditch/pastey-serv/internal/config"
+	"github.com/tombowditch/pastey-serv/internal/connlimit"
+	"github.com/tombowditch/pastey-serv/internal/logging"
+	"github.com/tombowditch/pastey-serv/internal/metrics"
 	"github.com/tombowditch/pastey-serv/internal/paste"
-	"github.com/tombowditch/pastey-serv/internal/store"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
 	"github.com/tombowditch/pastey-serv/internal/util/randutil"
 )
 
+const (
+	// requestIDLength is the length of the ID assigned to each connection for log correlation.
+	requestIDLength = 16
+	// handshakeTimeout bounds the TLS handshake on the TLS listener.
+	handshakeTimeout = 10 * time.Second
+)
+
+// Options configures optional Server behaviour.
+type Options struct {
+	// ProxyProtocol requires connections from Proxies to start with a
+	// PROXY protocol header carrying the real client address.
+	ProxyProtocol bool
+	Proxies       *clientip.Resolver
+
+	// MaxConns caps concurrent connections across all listeners; further
+	// connections are refused immediately. Zero means unlimited.
+	MaxConns int
+	// MaxConnsPerIP caps concurrent connections from one client IP. Zero means unlimited.
+	MaxConnsPerIP int
+	// MaxReadTime bounds the total time spent receiving a paste, however
+	// steadily the client sends. Zero means unlimited.
+	MaxReadTime time.Duration
+	// MinReadRate is the minimum average upload speed in bytes per second,
+	// enforced after a short grace period. Zero disables the check.
+	MinReadRate int
+	// FirstByteTimeout is how long to wait for a client to start sending, and
+	// IdleTimeout how long a pause ends a paste from clients that don't half-close.
+	FirstByteTimeout time.Duration
+	IdleTimeout      time.Duration
+	// EOFIdleTimeout is the longest pause allowed while waiting for the
+	// half-close after a "#pastey eof" directive. Zero means unlimited.
+	EOFIdleTimeout time.Duration
+	// EOFMaxReadTime and EOFMinReadRate replace MaxReadTime and MinReadRate
+	// after a "#pastey eof" directive. Zero means unlimited.
+	EOFMaxReadTime time.Duration
+	EOFMinReadRate int
+}
+
 // Server holds dependencies for the TCP server.
 type Server struct {
-	store store.Store
+	pastes    *paste.Service
+	opts      Options
+	listening atomic.Int32
+	conns     sync.WaitGroup
+	active    atomic.Int64
+	perIP     *connlimit.PerIP
 }
 
-// New creates a new TCP server with the given store.
-func New(s store.Store) *Server {
-	return &Server{store: s}
+// New creates a new TCP server using the given paste service.
+func New(pastes *paste.Service, opts Options) *Server {
+	if opts.Proxies == nil {
+		opts.Proxies = &clientip.Resolver{}
+	}
+	return &Server{pastes: pastes, opts: opts, perIP: connlimit.NewPerIP(opts.MaxConnsPerIP)}
 }
 
 // Serve starts listening on the given address and handles connections.
-// This function blocks until the listener fails.
-func (s *Server) Serve(addr string) error {
+// This function blocks until the listener fails or ctx is cancelled, then
+// waits for in-flight connections to finish. Each connection gets its own
+// context that keeps ctx's values but isn't cancelled by shutdown, so
+// uploads in progress still get stored.
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	return s.serve(ctx, addr, nil)
+}
+
+// ServeTLS is like Serve but clients must connect with TLS, e.g. using
+// openssl s_client or ncat --ssl.
+func (s *Server) ServeTLS(ctx context.Context, addr string, cfg *tls.Config) error {
+	return s.serve(ctx, addr, cfg)
+}
+
+func (s *Server) serve(ctx context.Context, addr string, tlsConfig *tls.Config) error {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return err
 	}
+	// The PROXY header precedes the TLS handshake
+	if s.opts.ProxyProtocol {
+		l = s.opts.Proxies.ProxyListener(l, config.ProxyHeaderTimeout)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
 	defer l.Close()
+	defer s.conns.Wait()
+
+	s.listening.Add(1)
+	defer s.listening.Add(-1)
 
-	slog.Info("tcp server listening", "addr", addr)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
 
+	slog.Info("tcp server listening", "addr", addr, "tls", tlsConfig != nil, "proxy_protocol", s.opts.ProxyProtocol)
+
+	var backoff time.Duration
 	for {
 		conn, err := l.Accept()
 		if err != nil {
-			slog.Error("error accepting connection", "error", err)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Usually out of file descriptors; back off rather than spin
+			backoff = acceptBackoff(backoff)
+			slog.Error("error accepting connection", "error", err, "retry_in", backoff)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+
+		if s.opts.MaxConns > 0 && s.active.Load() >= int64(s.opts.MaxConns) {
+			metrics.TCPRejectedConnections.WithLabelValues("max_conns").Inc()
+			// Off the accept loop: on TLS the write first needs a handshake,
+			// which a client that sends nothing would otherwise stall
+			s.conns.Add(1)
+			go func() {
+				defer s.conns.Done()
+				refuse(conn, "server busy, try again later")
+			}()
 			continue
 		}
-		go s.handleRequest(conn)
+		s.active.Add(1)
+		s.conns.Add(1)
+		go func() {
+			defer s.conns.Done()
+			defer s.active.Add(-1)
+			connCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+			defer cancel()
+			s.handleRequest(connCtx, conn)
+		}()
 	}
 }
 
-func (s *Server) handleRequest(conn net.Conn) {
-	defer conn.Close()
+// Check reports an error unless a listener is accepting connections.
+// It is intended as a readiness check.
+func (s *Server) Check(ctx context.Context) error {
+	if s.listening.Load() == 0 {
+		return errors.New("tcp listener not accepting connections")
+	}
+	return nil
+}
 
-	msg := make([]byte, 0)
-	buf := make([]byte, 1024)
-	bytesRead := 0
+// countingConn counts bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	bytesIn  int
+	bytesOut int
+}
 
-	// Check rate limit before reading
-	cip := strings.Split(conn.RemoteAddr().String(), ":")[0]
-	limiter := rate.NewLimiter(rate.Every(time.Second*5), 5, "pastey_rl_"+cip)
-	if !limiter.Allow() {
-		slog.Warn("rate limit exceeded", "ip", cip)
-		conn.Write([]byte("rate limit exceeded (1 paste per 5 seconds)\r\n"))
-		return
-	}
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bytesIn += n
+	return n, err
+}
 
-	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bytesOut += n
+	return n, err
+}
 
-	for {
-		n, err := conn.Read(buf)
-		if err != nil {
-			if netErr, ok := err.(net.Error); err != io.EOF && (!ok || !netErr.Timeout()) {
-				slog.Error("read error", "error", err, "ip", cip)
-				conn.Write([]byte("read err\r\n"))
-				return
-			}
-			break
-		}
+// accessLog emits one access log line per connection, mirroring the HTTP
+// server's so both can be queried together. Status uses HTTP status codes.
+type accessLog struct {
+	start    time.Time
+	conn     *countingConn
+	clientIP string
+	status   int
+	pasteID  string
+}
+
+func (a *accessLog) log(ctx context.Context) {
+	slog.InfoContext(ctx, "tcp access",
+		"status", a.status,
+		"bytes_in", a.conn.bytesIn,
+		"bytes_out", a.conn.bytesOut,
+		"duration", time.Since(a.start),
+		"remote", a.clientIP,
+		"paste_id", a.pasteID,
+	)
+}
 
-		bytesRead += n
+func (s *Server) handleRequest(ctx context.Context, rawConn net.Conn) {
+	defer rawConn.Close()
 
-		if bytesRead > config.MaxPayloadSize {
-			conn.Write([]byte("payload too big\r\n"))
+	metrics.TCPActiveConnections.Inc()
+	defer metrics.TCPActiveConnections.Dec()
+
+	if err := clientip.Handshake(rawConn); err != nil {
+		slog.WarnContext(ctx, "rejected connection from proxy", "error", err, "remote", clientip.FromAddr(rawConn.RemoteAddr()))
+		return
+	}
+	if tlsConn, ok := rawConn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(handshakeTimeout))
+		err := tlsConn.HandshakeContext(ctx)
+		tlsConn.SetDeadline(time.Time{})
+		if err != nil {
+			slog.DebugContext(ctx, "tls handshake failed", "error", err, "remote", clientip.FromAddr(rawConn.RemoteAddr()))
 			return
 		}
+	}
+
+	conn := &countingConn{Conn: rawConn}
+
+	cip := clientip.FromAddr(conn.RemoteAddr())
+	requestID := randutil.RandString(requestIDLength)
+	ctx = logging.WithRequestID(ctx, requestID)
 
-		msg = append(msg, buf[:n]...)
+	ctx, span := tracing.Start(ctx, "tcp.Connection",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("client.address", cip),
+			attribute.String("request.id", requestID),
+		),
+	)
+	defer span.End()
 
-		conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+	access := &accessLog{start: time.Now(), conn: conn, clientIP: cip, status: http.StatusCreated}
+	defer access.log(ctx)
+
+	if !s.perIP.Acquire(cip) {
+		metrics.TCPRejectedConnections.WithLabelValues("max_conns_per_ip").Inc()
+		access.status = http.StatusTooManyRequests
+		conn.Write([]byte("too many connections\r\n"))
+		return
 	}
+	defer s.perIP.Release(cip)
 
-	// Validate paste content
-	if err := paste.Validate(msg); err != nil {
-		if ve, ok := err.(*paste.ValidationError); ok {
-			// Convert message for TCP (use \r\n line endings)
-			tcpMsg := strings.ReplaceAll(ve.Message, "\n", "\r\n")
-			conn.Write([]byte(tcpMsg + "\r\n"))
-		} else {
-			conn.Write([]byte("error\r\n"))
-		}
+	// Clients that may neither read nor create are turned away unheard
+	if err := s.pastes.AllowConnect(ctx, cip); err != nil {
+		access.status = paste.StatusCode(err)
+		writeError(conn, err)
 		return
 	}
 
-	// Generate unique identifier and store atomically
-	var identifier string
-	for tried := 0; tried < 10; tried++ {
-		identifier = randutil.RandString(config.IDLength)
-		ok, err := s.store.Create(identifier, msg)
-		if err != nil {
-			slog.Error("store create failed", "error", err)
-			conn.Write([]byte("error, could not connect to db\r\n"))
-			return
-		}
-		if ok {
-			slog.Info("created paste via TCP", "identifier", identifier, "remote", cip)
-			conn.Write([]byte(config.BaseURL + identifier + "\r\n"))
-			return
-		}
-		// Collision, try again
-		slog.Debug("identifier collision, retrying", "identifier", identifier)
+	// The first line decides whether this is a read or an upload
+	up := s.newUpload(conn)
+	_, readSpan := tracing.Start(ctx, "tcp.Read")
+	head, err := up.readHead()
+	if err != nil {
+		tracing.End(readSpan, err)
+		uploadFailed(ctx, conn, access, err)
+		return
+	}
+	if id, ok := parseGet(head); ok {
+		readSpan.End()
+		s.servePaste(ctx, conn, id, cip, access)
+		return
 	}
 
-	// Failed to generate unique identifier after retries
-	slog.Error("could not generate unique identifier after retries")
-	conn.Write([]byte("error\r\n"))
+	// Check bans and rate limit before reading the rest of the body
+	if err := s.pastes.AllowCreate(ctx, cip); err != nil {
+		readSpan.End()
+		access.status = paste.StatusCode(err)
+		writeError(conn, err)
+		return
+	}
+
+	directive, err := up.directive()
+	if err != nil {
+		tracing.End(readSpan, err)
+		uploadFailed(ctx, conn, access, err)
+		return
+	}
+	if directive.Live {
+		readSpan.End()
+		s.streamPaste(ctx, conn, up, directive, access)
+		return
+	}
+
+	body, err := up.readPaste(directive)
+	readSpan.SetAttributes(attribute.Int("tcp.bytes_read", conn.bytesIn))
+	tracing.End(readSpan, err)
+	if err != nil {
+		uploadFailed(ctx, conn, access, err)
+		return
+	}
+
+	res, err := s.pastes.Create(ctx, paste.CreateRequest{
+		Body:     body,
+		Secure:   directive.Secure,
+		TTL:      directive.TTL,
+		Burn:     directive.Burn,
+		ClientIP: cip,
+		Channel:  paste.ChannelTCP,
+	})
+	if err != nil {
+		span.SetAttributes(attribute.String("tcp.result", paste.Message(err)))
+		access.status = paste.StatusCode(err)
+		writeError(conn, err)
+		return
+	}
+	access.pasteID = res.ID
+
+	conn.Write([]byte(res.URL + "\r\n"))
+	if notice := res.Notice(); notice != "" {
+		conn.Write([]byte(notice + "\r\n"))
+	}
+}
+
+// refuse tells a client its connection won't be served and closes it. The
+// deadline covers reads too, as a TLS handshake happens on the first write.
+func refuse(conn net.Conn, msg string) {
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte(msg + "\r\n"))
+	conn.Close()
+}
+
+// writeError writes an error returned by paste.Service to the connection,
+// converting line endings for TCP clients.
+func writeError(conn net.Conn, err error) {
+	tcpMsg := strings.ReplaceAll(paste.Message(err), "\n", "\r\n")
+	conn.Write([]byte(tcpMsg + "\r\n"))
 }
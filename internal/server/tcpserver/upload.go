@@ -0,0 +1,210 @@
+package tcpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/tombowditch/pastey-serv/internal/config"
+	"github.com/tombowditch/pastey-serv/internal/metrics"
+	"github.com/tombowditch/pastey-serv/internal/paste"
+)
+
+// maxDirectiveLength bounds how much is buffered while waiting for the end of
+// the first line, which may be a "#pastey" directive or a GET request.
+const maxDirectiveLength = 1024
+
+// uploadError is a failed upload, with the status and message for the client.
+type uploadError struct {
+	status int
+	msg    string
+	// limit is the connection limit that cut the upload off, for metrics, or "".
+	limit string
+	err   error
+}
+
+func (e *uploadError) Error() string {
+	if e.err != nil {
+		return e.msg + ": " + e.err.Error()
+	}
+	return e.msg
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.err
+}
+
+// upload reads what a client sends on a connection. The end of a paste is,
+// in order of preference:
+//
+//   - the client half-closing the connection (nc -N, shutdown(SHUT_WR));
+//   - exactly N bytes after a "#pastey length=N" directive line;
+//   - the client sending nothing for IdleTimeout, unless a "#pastey eof"
+//     directive says to wait for the half-close however long output takes.
+//
+// MaxReadTime and MinReadRate bound every upload, so a client trickling
+// bytes just inside the idle timeout can't hold a connection open. Pastes
+// after "#pastey eof" may come from commands that produce output slowly, so
+// they get the more generous EOFMaxReadTime and EOFMinReadRate instead, and
+// a pause longer than EOFIdleTimeout is an error rather than the end.
+// Live pastes (see streamPaste) are read until the half-close and bounded by
+// their own deadline instead.
+type upload struct {
+	conn  net.Conn
+	opts  Options
+	start time.Time
+	// limit is the absolute read deadline, or zero if there is none.
+	limit time.Time
+	buf   []byte
+	data  []byte
+	// headerLen is the length of the directive line at the start of data.
+	headerLen int
+	// done is set once the client has half-closed or gone idle, and idle
+	// only in the latter case.
+	done bool
+	idle bool
+}
+
+func (s *Server) newUpload(conn net.Conn) *upload {
+	u := &upload{conn: conn, opts: s.opts, start: time.Now(), buf: make([]byte, 32*1024)}
+	if s.opts.MaxReadTime > 0 {
+		u.limit = u.start.Add(s.opts.MaxReadTime)
+	}
+	return u
+}
+
+// read reads once, waiting at most idle for data (or only until the absolute
+// limit if idle is zero), and checks the upload limits, including an average
+// of at least minRate bytes per second unless it is zero. A half-close or
+// idle timeout sets done.
+func (u *upload) read(idle time.Duration, minRate int) error {
+	deadline := u.limit
+	if idle > 0 && (deadline.IsZero() || time.Now().Add(idle).Before(deadline)) {
+		deadline = time.Now().Add(idle)
+	}
+	u.conn.SetReadDeadline(deadline)
+
+	n, err := u.conn.Read(u.buf)
+	u.data = append(u.data, u.buf[:n]...)
+	if len(u.data) > config.MaxPayloadSize {
+		return &uploadError{status: http.StatusRequestEntityTooLarge, msg: "payload too big"}
+	}
+
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case err == io.EOF:
+		case errors.As(err, &netErr) && netErr.Timeout():
+			if !u.limit.IsZero() && !time.Now().Before(u.limit) {
+				return &uploadError{status: http.StatusRequestTimeout, msg: "upload took too long", limit: "read_timeout"}
+			}
+			u.idle = true
+		default:
+			return &uploadError{status: http.StatusBadRequest, msg: "read err", err: err}
+		}
+		u.done = true
+		return nil
+	}
+
+	if elapsed := time.Since(u.start); minRate > 0 && elapsed > minRateGrace &&
+		float64(len(u.data)) < float64(minRate)*elapsed.Seconds() {
+		return &uploadError{status: http.StatusRequestTimeout, msg: "upload too slow", limit: "too_slow"}
+	}
+	return nil
+}
+
+// readHead reads until the first line is complete, maxDirectiveLength bytes
+// have arrived or the client stops sending, and returns the data so far.
+func (u *upload) readHead() ([]byte, error) {
+	idle := u.opts.FirstByteTimeout
+	for !u.done && bytes.IndexByte(u.data, '\n') < 0 && len(u.data) < maxDirectiveLength {
+		if err := u.read(idle, u.opts.MinReadRate); err != nil {
+			return nil, err
+		}
+		idle = u.opts.IdleTimeout
+	}
+	return u.data, nil
+}
+
+// directive parses the directive line, if any, from the data read by
+// readHead. Errors are a *paste.ValidationError.
+func (u *upload) directive() (paste.Directive, error) {
+	directive, body, err := paste.ParseDirective(u.data)
+	if err != nil {
+		return paste.Directive{}, err
+	}
+	u.headerLen = len(u.data) - len(body)
+	return directive, nil
+}
+
+// readPaste reads the rest of a paste with the given directive and returns
+// its body. Errors are an *uploadError.
+func (u *upload) readPaste(directive paste.Directive) ([]byte, error) {
+	if directive.Length > config.MaxPayloadSize {
+		return nil, &uploadError{status: http.StatusRequestEntityTooLarge, msg: "payload too big"}
+	}
+
+	idle, minRate := u.opts.IdleTimeout, u.opts.MinReadRate
+	switch {
+	case directive.Length > 0:
+		idle = 0
+	case directive.WaitEOF:
+		idle, minRate = u.opts.EOFIdleTimeout, u.opts.EOFMinReadRate
+		u.limit = time.Time{}
+		if u.opts.EOFMaxReadTime > 0 {
+			u.limit = u.start.Add(u.opts.EOFMaxReadTime)
+		}
+	}
+	for !u.done {
+		if directive.Length > 0 && len(u.data)-u.headerLen >= directive.Length {
+			break
+		}
+		if err := u.read(idle, minRate); err != nil {
+			return nil, err
+		}
+	}
+	if directive.WaitEOF && u.idle {
+		return nil, &uploadError{
+			status: http.StatusRequestTimeout,
+			msg:    "nothing sent for " + idle.String() + " before the connection was half-closed",
+			limit:  "eof_idle_timeout",
+		}
+	}
+
+	body := u.data[u.headerLen:]
+	if directive.Length > 0 {
+		if len(body) < directive.Length {
+			return nil, &uploadError{
+				status: http.StatusBadRequest,
+				msg:    "upload ended after " + strconv.Itoa(len(body)) + " of " + strconv.Itoa(directive.Length) + " bytes",
+			}
+		}
+		body = body[:directive.Length]
+	}
+	return body, nil
+}
+
+// uploadFailed reports an error from reading an upload to the client.
+func uploadFailed(ctx context.Context, conn net.Conn, access *accessLog, err error) {
+	var ue *uploadError
+	if !errors.As(err, &ue) {
+		// Invalid "#pastey ..." directive line
+		access.status = paste.StatusCode(err)
+		writeError(conn, err)
+		return
+	}
+	if ue.err != nil {
+		slog.ErrorContext(ctx, "read error", "error", ue.err, "ip", access.clientIP)
+	}
+	if ue.limit != "" {
+		metrics.TCPRejectedConnections.WithLabelValues(ue.limit).Inc()
+	}
+	access.status = ue.status
+	conn.Write([]byte(ue.msg + "\r\n"))
+}
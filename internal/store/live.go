@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	livePrefix    = "pastey_live_"
+	liveEndPrefix = "pastey_live_end_"
+
+	// liveGrace is how long a live paste's content stays readable after its
+	// upload ends, so viewers polling for more can pick up the final data.
+	liveGrace = time.Minute
+)
+
+// Streamer is implemented by stores that can hold live pastes, whose content
+// is appended while it is uploaded and read by viewers as it grows.
+type Streamer interface {
+	// StartLive reserves id for a live paste kept for at most ttl.
+	// Returns false if the ID is already taken.
+	StartLive(ctx context.Context, id string, ttl time.Duration) (bool, error)
+	// AppendLive adds data to the end of a live paste. Returns ErrNotFound if
+	// it has expired or been removed by a moderator.
+	AppendLive(ctx context.Context, id string, data []byte) error
+	// Live reports whether id is a live paste that is still being uploaded.
+	Live(ctx context.Context, id string) (bool, error)
+	// ReadLive returns a live paste's content from offset onwards and whether
+	// its upload has ended. Returns ErrNotFound if id isn't a live paste.
+	ReadLive(ctx context.Context, id string, offset int) ([]byte, bool, error)
+	// FinishLive stores a live paste whose upload has ended as a normal paste
+	// with the given content and metadata, and ends its stream. Returns
+	// ErrNotFound if the live paste has expired or been removed.
+	FinishLive(ctx context.Context, id string, body []byte, meta Meta) error
+	// AbortLive discards a live paste and ends its stream.
+	AbortLive(ctx context.Context, id string) error
+}
+
+// appendExisting appends ARGV[1] to KEYS[1] only if it exists, so an upload
+// can't recreate a live paste without an expiry after it expired or was
+// removed. Returns the new length, or -1 if the key doesn't exist.
+var appendExisting = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("APPEND", KEYS[1], ARGV[1])
+`)
+
+// StartLive reserves id for a live paste, unless it is taken by a stored or
+// tombstoned paste.
+func (s *RedisStore) StartLive(ctx context.Context, id string, ttl time.Duration) (ok bool, err error) {
+	ctx, end := s.begin(ctx, "start_live", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	plain := pipe.Exists(ctx, keyPrefix+id)
+	burn := pipe.Exists(ctx, burnPrefix+id)
+	gone := pipe.Exists(ctx, gonePrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	if plain.Val()+burn.Val()+gone.Val() > 0 {
+		return false, nil
+	}
+	return s.client.SetNX(ctx, livePrefix+id, "", ttl).Result()
+}
+
+// AppendLive appends data to a live paste.
+func (s *RedisStore) AppendLive(ctx context.Context, id string, data []byte) (err error) {
+	ctx, end := s.begin(ctx, "append_live", id)
+	defer func() { end(err) }()
+
+	n, err := appendExisting.Run(ctx, s.client, []string{livePrefix + id}, data).Int()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Live reports whether id is a live paste whose upload hasn't ended.
+func (s *RedisStore) Live(ctx context.Context, id string) (live bool, err error) {
+	ctx, end := s.begin(ctx, "live", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	data := pipe.Exists(ctx, livePrefix+id)
+	ended := pipe.Exists(ctx, liveEndPrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return data.Val() > 0 && ended.Val() == 0, nil
+}
+
+// ReadLive returns a live paste's content from offset onwards. The end marker
+// is read before the content, so content read after seeing it is complete.
+func (s *RedisStore) ReadLive(ctx context.Context, id string, offset int) (data []byte, ended bool, err error) {
+	ctx, end := s.begin(ctx, "read_live", id)
+	defer func() { end(err) }()
+
+	n, err := s.client.Exists(ctx, liveEndPrefix+id).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	pipe := s.client.Pipeline()
+	exists := pipe.Exists(ctx, livePrefix+id)
+	content := pipe.GetRange(ctx, livePrefix+id, int64(offset), -1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, false, err
+	}
+	if exists.Val() == 0 {
+		return nil, false, ErrNotFound
+	}
+	return []byte(content.Val()), n > 0, nil
+}
+
+// FinishLive stores the completed paste under the live paste's ID, then marks
+// the stream ended and lets its content expire after a grace period.
+func (s *RedisStore) FinishLive(ctx context.Context, id string, body []byte, meta Meta) (err error) {
+	ctx, end := s.begin(ctx, "finish_live", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	live := pipe.Exists(ctx, livePrefix+id)
+	gone := pipe.Exists(ctx, gonePrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if live.Val() == 0 || gone.Val() > 0 {
+		return ErrNotFound
+	}
+
+	ok, err := s.put(ctx, id, body, meta)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// Only possible if the reservation expired and the ID was reused
+		return ErrNotFound
+	}
+	return s.endLive(ctx, id, false)
+}
+
+// AbortLive removes a live paste's content and marks its stream ended.
+func (s *RedisStore) AbortLive(ctx context.Context, id string) (err error) {
+	ctx, end := s.begin(ctx, "abort_live", id)
+	defer func() { end(err) }()
+
+	return s.endLive(ctx, id, true)
+}
+
+// endLive marks a live stream ended, deleting its content or keeping it for
+// liveGrace.
+func (s *RedisStore) endLive(ctx context.Context, id string, discard bool) error {
+	pipe := s.client.Pipeline()
+	pipe.Set(ctx, liveEndPrefix+id, "", liveGrace)
+	if discard {
+		pipe.Del(ctx, livePrefix+id)
+	} else {
+		pipe.Expire(ctx, livePrefix+id, liveGrace)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	metaPrefix = "pastey_meta_"
+	gonePrefix = "pastey_gone_"
+	banPrefix  = "pastey_ban_"
+	recentKey  = "pastey_recent"
+
+	// recentMax caps the size of the recent creations index.
+	recentMax = 1000
+)
+
+// Moderator is implemented by stores that support moderating pastes.
+type Moderator interface {
+	// Meta returns metadata for a paste. Returns ErrNotFound or *GoneError like Get.
+	Meta(ctx context.Context, id string) (Meta, error)
+	// Peek returns a paste like Get but leaves burn-after-reading pastes in
+	// place, for moderators to review them.
+	Peek(ctx context.Context, id string) (Paste, error)
+	// Delete removes a paste so its ID reads as not found.
+	Delete(ctx context.Context, id string) error
+	// Tombstone removes a paste and makes its ID report *GoneError with reason.
+	Tombstone(ctx context.Context, id, reason string) error
+	// Recent returns metadata for up to limit of the most recently created pastes, newest first.
+	Recent(ctx context.Context, limit int) ([]Meta, error)
+}
+
+// Ban describes a banned client IP.
+type Ban struct {
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+	// ExpiresAt is nil for permanent bans.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Banlist is implemented by stores that can record banned client IPs.
+type Banlist interface {
+	// Ban bans ip for ttl, or permanently if ttl is zero.
+	Ban(ctx context.Context, ip, reason string, ttl time.Duration) error
+	Unban(ctx context.Context, ip string) error
+	// Banned returns the ban for ip, or nil if it isn't banned.
+	Banned(ctx context.Context, ip string) (*Ban, error)
+}
+
+// saveMeta stores paste metadata and indexes it as a recent creation.
+// The commands are pipelined rather than run in a transaction because
+// the keys may live on different Redis Cluster nodes.
+func (s *RedisStore) saveMeta(ctx context.Context, id string, meta Meta, ttl time.Duration) error {
+	pipe := s.client.Pipeline()
+	pipe.HSet(ctx, metaPrefix+id,
+		"created_at", meta.CreatedAt.UnixMilli(),
+		"client_ip", meta.ClientIP,
+		"channel", meta.Channel,
+		"owner", meta.Owner,
+		"size", meta.Size,
+		"flags", strings.Join(meta.Flags, ","),
+		"burn", strconv.FormatBool(meta.Burn),
+	)
+	pipe.Expire(ctx, metaPrefix+id, ttl)
+	pipe.ZAdd(ctx, recentKey, redis.Z{Score: float64(meta.CreatedAt.UnixMilli()), Member: id})
+	pipe.ZRemRangeByRank(ctx, recentKey, 0, -recentMax-1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// missing distinguishes a tombstoned paste from one that never existed or expired.
+func (s *RedisStore) missing(ctx context.Context, id string) error {
+	reason, err := s.client.Get(ctx, gonePrefix+id).Result()
+	if err == redis.Nil {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return &GoneError{Reason: reason}
+}
+
+// Meta returns metadata for a paste.
+func (s *RedisStore) Meta(ctx context.Context, id string) (meta Meta, err error) {
+	ctx, end := s.begin(ctx, "meta", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	fields := pipe.HGetAll(ctx, metaPrefix+id)
+	ttl := pipe.PTTL(ctx, keyPrefix+id)
+	burnTTL := pipe.PTTL(ctx, burnPrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Meta{}, err
+	}
+
+	// -2: the paste doesn't exist; -1 can't happen as pastes always expire
+	remaining := max(ttl.Val(), burnTTL.Val())
+	if remaining < 0 {
+		return Meta{}, s.missing(ctx, id)
+	}
+	meta = parseMeta(id, fields.Val())
+	meta.ExpiresAt = time.Now().Add(remaining).UTC()
+	return meta, nil
+}
+
+// Peek retrieves a paste by ID without deleting it if it is burn-after-reading.
+func (s *RedisStore) Peek(ctx context.Context, id string) (p Paste, err error) {
+	ctx, end := s.begin(ctx, "peek", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	plain := pipe.Get(ctx, keyPrefix+id)
+	ttl := pipe.PTTL(ctx, keyPrefix+id)
+	burn := pipe.Get(ctx, burnPrefix+id)
+	burnTTL := pipe.PTTL(ctx, burnPrefix+id)
+	created := pipe.HGet(ctx, metaPrefix+id, "created_at")
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return Paste{}, err
+	}
+
+	if ms, err := created.Int64(); err == nil {
+		p.CreatedAt = time.UnixMilli(ms).UTC()
+	}
+	if val, err := plain.Result(); err == nil {
+		p.Body = val
+	} else if val, err := burn.Result(); err == nil {
+		p.Body, p.Burn = val, true
+		ttl = burnTTL
+	} else {
+		return Paste{}, s.missing(ctx, id)
+	}
+	if ttl.Val() > 0 {
+		p.ExpiresAt = time.Now().Add(ttl.Val()).UTC()
+	}
+	return p, nil
+}
+
+// Delete removes a paste and its metadata, cutting off a live paste's upload.
+func (s *RedisStore) Delete(ctx context.Context, id string) (err error) {
+	ctx, end := s.begin(ctx, "delete", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	deleted := pipe.Del(ctx, keyPrefix+id)
+	deletedBurn := pipe.Del(ctx, burnPrefix+id)
+	deletedLive := pipe.Del(ctx, livePrefix+id)
+	pipe.Del(ctx, metaPrefix+id)
+	pipe.ZRem(ctx, recentKey, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if deleted.Val()+deletedBurn.Val()+deletedLive.Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Tombstone removes a paste and records reason against its ID for the paste TTL.
+// Tombstoning an ID that doesn't exist is allowed, so links can be blocked pre-emptively.
+func (s *RedisStore) Tombstone(ctx context.Context, id, reason string) (err error) {
+	ctx, end := s.begin(ctx, "tombstone", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	pipe.Set(ctx, gonePrefix+id, reason, s.ttl)
+	pipe.Del(ctx, keyPrefix+id)
+	pipe.Del(ctx, burnPrefix+id)
+	pipe.Del(ctx, livePrefix+id)
+	pipe.Del(ctx, metaPrefix+id)
+	pipe.ZRem(ctx, recentKey, id)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Recent returns metadata for the most recently created pastes that still exist.
+func (s *RedisStore) Recent(ctx context.Context, limit int) (metas []Meta, err error) {
+	ctx, end := s.begin(ctx, "recent", "")
+	defer func() { end(err) }()
+
+	ids, err := s.client.ZRevRange(ctx, recentKey, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := s.client.Pipeline()
+	fields := make([]*redis.MapStringStringCmd, len(ids))
+	ttls := make([]*redis.DurationCmd, len(ids))
+	for i, id := range ids {
+		fields[i] = pipe.HGetAll(ctx, metaPrefix+id)
+		ttls[i] = pipe.PTTL(ctx, metaPrefix+id)
+	}
+	if len(ids) > 0 {
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	metas = make([]Meta, 0, len(ids))
+	for i, id := range ids {
+		// Expired pastes linger in the index until trimmed; skip them
+		if len(fields[i].Val()) == 0 {
+			continue
+		}
+		meta := parseMeta(id, fields[i].Val())
+		meta.ExpiresAt = now.Add(ttls[i].Val()).UTC()
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// parseMeta builds Meta from the hash written by saveMeta.
+func parseMeta(id string, fields map[string]string) Meta {
+	meta := Meta{
+		ID:       id,
+		ClientIP: fields["client_ip"],
+		Channel:  fields["channel"],
+		Owner:    fields["owner"],
+	}
+	if ms, err := strconv.ParseInt(fields["created_at"], 10, 64); err == nil {
+		meta.CreatedAt = time.UnixMilli(ms).UTC()
+	}
+	meta.Size, _ = strconv.Atoi(fields["size"])
+	meta.Burn, _ = strconv.ParseBool(fields["burn"])
+	if flags := fields["flags"]; flags != "" {
+		meta.Flags = strings.Split(flags, ",")
+	}
+	return meta
+}
+
+// Ban records ip as banned.
+func (s *RedisStore) Ban(ctx context.Context, ip, reason string, ttl time.Duration) (err error) {
+	ctx, end := s.begin(ctx, "ban", "")
+	defer func() { end(err) }()
+
+	return s.client.Set(ctx, banPrefix+ip, reason, ttl).Err()
+}
+
+// Unban lifts a ban on ip.
+func (s *RedisStore) Unban(ctx context.Context, ip string) (err error) {
+	ctx, end := s.begin(ctx, "unban", "")
+	defer func() { end(err) }()
+
+	n, err := s.client.Del(ctx, banPrefix+ip).Result()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Banned returns the ban for ip, or nil if it isn't banned.
+func (s *RedisStore) Banned(ctx context.Context, ip string) (ban *Ban, err error) {
+	ctx, end := s.begin(ctx, "banned", "")
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	reason := pipe.Get(ctx, banPrefix+ip)
+	ttl := pipe.PTTL(ctx, banPrefix+ip)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	if errors.Is(reason.Err(), redis.Nil) {
+		return nil, nil
+	}
+
+	ban = &Ban{IP: ip, Reason: reason.Val()}
+	if ttl.Val() > 0 {
+		expires := time.Now().Add(ttl.Val()).UTC()
+		ban.ExpiresAt = &expires
+	}
+	return ban, nil
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisConfig describes how to connect to Redis.
+// Exactly one topology is used: Cluster if ClusterAddrs is set, Sentinel if
+// SentinelMaster is set, otherwise a single standalone server.
+type RedisConfig struct {
+	// URI is a redis:// or rediss:// URL (credentials and DB index included),
+	// or a bare host:port. For Sentinel and Cluster only its credentials,
+	// DB index and TLS scheme are used.
+	URI string
+
+	// SentinelMaster is the name of the master monitored by SentinelAddrs.
+	SentinelMaster string
+	SentinelAddrs  []string
+
+	// ClusterAddrs are the seed nodes of a Redis Cluster.
+	ClusterAddrs []string
+
+	// TLSCAFile is a PEM bundle used to verify the server certificate.
+	// Setting it enables TLS even for a redis:// URL.
+	TLSCAFile string
+}
+
+// NewRedisClient creates a client for the topology described by cfg.
+// The client is shared by the store and the rate limiter.
+func NewRedisClient(cfg RedisConfig) (redis.UniversalClient, error) {
+	opts, err := parseRedisURI(cfg.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.TLSCAFile != "" {
+		pem, err := os.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading redis CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("redis CA file contains no certificates")
+		}
+		if opts.TLSConfig == nil {
+			opts.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		opts.TLSConfig.RootCAs = pool
+	}
+
+	switch {
+	case len(cfg.ClusterAddrs) > 0:
+		if opts.DB != 0 {
+			return nil, errors.New("redis cluster does not support selecting a DB")
+		}
+		if opts.TLSConfig != nil {
+			// The URL host is irrelevant in cluster mode; verify each node by its own name.
+			opts.TLSConfig.ServerName = ""
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:                 cfg.ClusterAddrs,
+			Username:              opts.Username,
+			Password:              opts.Password,
+			TLSConfig:             opts.TLSConfig,
+			ContextTimeoutEnabled: true,
+		}), nil
+	case cfg.SentinelMaster != "":
+		if len(cfg.SentinelAddrs) == 0 {
+			return nil, errors.New("redis sentinel master set without sentinel addresses")
+		}
+		if opts.TLSConfig != nil {
+			opts.TLSConfig.ServerName = ""
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:            cfg.SentinelMaster,
+			SentinelAddrs:         cfg.SentinelAddrs,
+			Username:              opts.Username,
+			Password:              opts.Password,
+			DB:                    opts.DB,
+			TLSConfig:             opts.TLSConfig,
+			ContextTimeoutEnabled: true,
+		}), nil
+	default:
+		opts.ContextTimeoutEnabled = true
+		return redis.NewClient(opts), nil
+	}
+}
+
+// parseRedisURI parses a redis:// or rediss:// URL, falling back to treating
+// uri as a bare host:port (including bracketed IPv6) for older deployments.
+func parseRedisURI(uri string) (*redis.Options, error) {
+	if uri == "" {
+		return &redis.Options{Addr: "localhost:6379"}, nil
+	}
+	if !strings.Contains(uri, "://") {
+		return &redis.Options{Addr: uri}, nil
+	}
+	opts, err := redis.ParseURL(uri)
+	if err != nil {
+		return nil, fmt.Errorf("parsing redis URI: %w", err)
+	}
+	return opts, nil
+}
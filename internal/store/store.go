@@ -1,92 +1,230 @@
 package store
 
 import (
+	"context"
 	"errors"
-	"strconv"
-	"strings"
+	"log/slog"
 	"time"
 
-	"github.com/go-redis/redis"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tombowditch/pastey-serv/internal/metrics"
+	"github.com/tombowditch/pastey-serv/internal/tracing"
 )
 
-const keyPrefix = "pastey_"
+const (
+	keyPrefix = "pastey_"
+	// burnPrefix holds burn-after-reading pastes, which Get removes with GETDEL
+	// so only one reader can ever see them.
+	burnPrefix = "pastey_burn_"
+)
 
 // ErrNotFound is returned when a paste doesn't exist or has expired.
 var ErrNotFound = errors.New("paste not found")
 
+// GoneError is returned when a paste has been taken down by a moderator.
+type GoneError struct {
+	Reason string
+}
+
+func (e *GoneError) Error() string {
+	return "paste removed: " + e.Reason
+}
+
+// Paste is a paste's content with the details needed to serve it.
+type Paste struct {
+	Body string
+	// CreatedAt is zero if the paste's metadata is missing.
+	CreatedAt time.Time
+	// ExpiresAt is zero for burn-after-reading pastes, which Get deletes.
+	ExpiresAt time.Time
+	Burn      bool
+}
+
+// Meta describes a stored paste.
+type Meta struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ClientIP  string    `json:"client_ip"`
+	Channel   string    `json:"channel"`
+	// Owner identifies the authenticated uploader, if any.
+	Owner string `json:"owner,omitempty"`
+	Size  int    `json:"size"`
+	// Flags lists content filter rules the paste matched.
+	Flags []string `json:"flags,omitempty"`
+	// Burn deletes the paste when it is first read.
+	Burn bool `json:"burn,omitempty"`
+	// TTL overrides the store's default lifetime when non-zero.
+	TTL time.Duration `json:"-"`
+}
+
 // Store defines the interface for paste storage operations.
+// Every method honours cancellation and deadlines of the given context.
 type Store interface {
-	// Get retrieves a paste by ID. Returns ErrNotFound if it doesn't exist.
-	Get(id string) (string, error)
-	// Create attempts to store a paste with the given ID.
+	// Get retrieves a paste by ID, deleting it if it is burn-after-reading.
+	// Returns ErrNotFound if it doesn't exist, or a *GoneError if it was taken down.
+	Get(ctx context.Context, id string) (Paste, error)
+	// Create attempts to store a paste with the given ID and metadata.
 	// Returns true if created, false if ID already exists (collision).
-	Create(id string, body []byte) (bool, error)
+	Create(ctx context.Context, id string, body []byte, meta Meta) (bool, error)
 }
 
-// RedisStore implements Store using Redis.
+// Pinger is implemented by stores that can report whether their backend is reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// RedisStore implements Store, Pinger, Moderator, Banlist, Streamer and Uploader using Redis.
 type RedisStore struct {
-	client *redis.Client
-	ttl    time.Duration
+	client  redis.UniversalClient
+	ttl     time.Duration
+	timeout time.Duration
 }
 
-// NewRedis creates a new Redis-backed store and verifies connectivity.
-func NewRedis(addr, password string, db int, ttl time.Duration) (*RedisStore, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
+// NewRedis creates a new Redis-backed store on client and verifies connectivity.
+// Each operation is bounded by timeout in addition to the caller's context;
+// a zero timeout leaves only the caller's context in effect.
+func NewRedis(client redis.UniversalClient, ttl, timeout time.Duration) (*RedisStore, error) {
+	s := &RedisStore{
+		client:  client,
+		ttl:     ttl,
+		timeout: timeout,
+	}
 
-	if _, err := client.Ping().Result(); err != nil {
+	if err := s.Ping(context.Background()); err != nil {
 		return nil, err
 	}
 
-	return &RedisStore{
-		client: client,
-		ttl:    ttl,
-	}, nil
+	return s, nil
+}
+
+// withTimeout bounds ctx by the store's per-operation timeout.
+func (s *RedisStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// Ping checks that Redis is reachable.
+func (s *RedisStore) Ping(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+	return s.client.Ping(ctx).Err()
+}
+
+// begin bounds ctx by the per-operation timeout and starts a span and latency
+// timer for a store operation. The returned function must be called with the
+// operation's result to end them; missing and removed pastes are not failures.
+func (s *RedisStore) begin(ctx context.Context, operation, id string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, cancel := s.withTimeout(ctx)
+	ctx, span := tracing.Start(ctx, "store."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("paste.id", id)),
+	)
+
+	return ctx, func(err error) {
+		cancel()
+		metrics.StoreDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		var gone *GoneError
+		if errors.Is(err, ErrNotFound) || errors.As(err, &gone) {
+			span.SetAttributes(attribute.Bool("paste.found", false))
+			err = nil
+		}
+		tracing.End(span, err)
+	}
 }
 
-// Get retrieves a paste by ID.
-func (s *RedisStore) Get(id string) (string, error) {
-	val, err := s.client.Get(keyPrefix + id).Result()
-	if err == redis.Nil {
-		return "", ErrNotFound
+// Get retrieves a paste by ID. Burn-after-reading pastes are deleted in the
+// same round trip, and their metadata removed afterwards.
+func (s *RedisStore) Get(ctx context.Context, id string) (p Paste, err error) {
+	ctx, end := s.begin(ctx, "get", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	plain := pipe.Get(ctx, keyPrefix+id)
+	ttl := pipe.PTTL(ctx, keyPrefix+id)
+	burn := pipe.GetDel(ctx, burnPrefix+id)
+	created := pipe.HGet(ctx, metaPrefix+id, "created_at")
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return Paste{}, err
 	}
-	if err != nil {
-		return "", err
+
+	if ms, err := created.Int64(); err == nil {
+		p.CreatedAt = time.UnixMilli(ms).UTC()
+	}
+	if val, err := plain.Result(); err == nil {
+		p.Body = val
+		if ttl.Val() > 0 {
+			p.ExpiresAt = time.Now().Add(ttl.Val()).UTC()
+		}
+		return p, nil
+	}
+	if val, err := burn.Result(); err == nil {
+		pipe := s.client.Pipeline()
+		pipe.Del(ctx, metaPrefix+id)
+		pipe.ZRem(ctx, recentKey, id)
+		if _, err := pipe.Exec(ctx); err != nil {
+			slog.ErrorContext(ctx, "removing burned paste metadata failed", "error", err, "identifier", id)
+		}
+		p.Body, p.Burn = val, true
+		return p, nil
 	}
-	return val, nil
+	return Paste{}, s.missing(ctx, id)
 }
 
 // Create stores a paste using SetNX (atomic set-if-not-exists).
 // Returns true if the paste was created, false if the ID already exists.
-func (s *RedisStore) Create(id string, body []byte) (bool, error) {
-	ok, err := s.client.SetNX(keyPrefix+id, string(body), s.ttl).Result()
-	if err != nil {
+// Metadata is stored alongside with the same TTL and the paste is added to
+// the recent creations index. Tombstoned IDs, IDs reserved by a live paste
+// and IDs taken by a paste of the other kind (burn-after-reading or not) are
+// reported as collisions.
+func (s *RedisStore) Create(ctx context.Context, id string, body []byte, meta Meta) (ok bool, err error) {
+	ctx, end := s.begin(ctx, "create", id)
+	defer func() { end(err) }()
+
+	otherKey := burnPrefix + id
+	if meta.Burn {
+		otherKey = keyPrefix + id
+	}
+
+	// Separate commands rather than one multi-key EXISTS, which Cluster rejects
+	pipe := s.client.Pipeline()
+	gone := pipe.Exists(ctx, gonePrefix+id)
+	other := pipe.Exists(ctx, otherKey)
+	live := pipe.Exists(ctx, livePrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
 		return false, err
 	}
-	return ok, nil
+	if gone.Val() > 0 || other.Val() > 0 || live.Val() > 0 {
+		return false, nil
+	}
+	return s.put(ctx, id, body, meta)
 }
 
-// ParseRedisURI parses a Redis URI in the form "host:port" and returns host and port separately.
-// This is needed because the rate limiter package takes host and port as separate config fields.
-func ParseRedisURI(uri string) (host string, port int) {
-	host = "localhost"
-	port = 6379
-
-	if uri == "" {
-		return
+// put stores a paste and its metadata unless the paste's key already exists.
+func (s *RedisStore) put(ctx context.Context, id string, body []byte, meta Meta) (bool, error) {
+	key := keyPrefix + id
+	if meta.Burn {
+		key = burnPrefix + id
+	}
+	ttl := s.ttl
+	if meta.TTL > 0 {
+		ttl = meta.TTL
 	}
 
-	parts := strings.Split(uri, ":")
-	if len(parts) >= 1 {
-		host = parts[0]
+	ok, err := s.client.SetNX(ctx, key, body, ttl).Result()
+	if err != nil || !ok {
+		return false, err
 	}
-	if len(parts) >= 2 {
-		if p, err := strconv.Atoi(parts[1]); err == nil {
-			port = p
-		}
+
+	if err := s.saveMeta(ctx, id, meta, ttl); err != nil {
+		// The paste itself is stored; missing metadata only affects moderation.
+		slog.ErrorContext(ctx, "store paste metadata failed", "error", err, "identifier", id)
 	}
-	return
+	return true, nil
 }
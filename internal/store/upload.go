@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	uploadPrefix     = "pastey_upload_"
+	uploadDataPrefix = "pastey_upload_data_"
+)
+
+// ErrOffsetMismatch is returned when data is appended to a resumable upload
+// at an offset other than its current size.
+var ErrOffsetMismatch = errors.New("upload offset mismatch")
+
+// Upload describes a resumable upload and the paste it will create.
+type Upload struct {
+	ID     string
+	Length int64
+	// Offset is how many bytes have been received so far.
+	Offset   int64
+	Secure   bool
+	TTL      time.Duration
+	Burn     bool
+	ClientIP string
+	// Claimed is set once a request has begun storing the complete upload as
+	// a paste, and PasteID once it has.
+	Claimed bool
+	PasteID string
+}
+
+// Uploader is implemented by stores that can hold resumable uploads, which
+// are received in chunks across several requests before becoming a paste.
+type Uploader interface {
+	// CreateUpload records a new upload, kept for ttl after its last change.
+	CreateUpload(ctx context.Context, upload Upload, ttl time.Duration) error
+	// GetUpload returns an upload's details and current offset.
+	// Returns ErrNotFound if it doesn't exist or has expired.
+	GetUpload(ctx context.Context, id string) (Upload, error)
+	// AppendUpload adds data at offset and returns the new offset. Returns
+	// ErrOffsetMismatch if offset isn't the current offset, or ErrNotFound.
+	AppendUpload(ctx context.Context, id string, offset int64, data []byte, ttl time.Duration) (int64, error)
+	// UploadData returns all data received for an upload.
+	UploadData(ctx context.Context, id string) ([]byte, error)
+	// ClaimUpload marks a complete upload as being stored as a paste, so
+	// only one request stores it. Returns false if it was already claimed,
+	// or ErrNotFound.
+	ClaimUpload(ctx context.Context, id string) (bool, error)
+	// CompleteUpload discards an upload's data and records the paste created
+	// from it, so clients that lost the final response can look it up.
+	CompleteUpload(ctx context.Context, id, pasteID string) error
+	// DeleteUpload discards an upload. Returns ErrNotFound if it doesn't exist.
+	DeleteUpload(ctx context.Context, id string) error
+}
+
+// appendAt appends ARGV[2] to KEYS[1] if its length is ARGV[1] and refreshes
+// its expiry to ARGV[3] milliseconds. Returns the new length, or -1 with the
+// current length if the offset doesn't match.
+var appendAt = redis.NewScript(`
+local size = redis.call("STRLEN", KEYS[1])
+if size ~= tonumber(ARGV[1]) then
+	return {-1, size}
+end
+local n = redis.call("APPEND", KEYS[1], ARGV[2])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return {n, n}
+`)
+
+// claim sets the "claimed" field of the hash KEYS[1] if it exists and the
+// field isn't set yet. Returns 1 if it was set, 0 if it already was, or -1 if
+// the hash doesn't exist.
+var claim = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("HSETNX", KEYS[1], "claimed", "1")
+`)
+
+// CreateUpload stores the upload's details. Its data key is created by the
+// first append.
+func (s *RedisStore) CreateUpload(ctx context.Context, upload Upload, ttl time.Duration) (err error) {
+	ctx, end := s.begin(ctx, "create_upload", upload.ID)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	pipe.HSet(ctx, uploadPrefix+upload.ID,
+		"length", upload.Length,
+		"secure", strconv.FormatBool(upload.Secure),
+		"ttl", upload.TTL.Milliseconds(),
+		"burn", strconv.FormatBool(upload.Burn),
+		"client_ip", upload.ClientIP,
+	)
+	pipe.PExpire(ctx, uploadPrefix+upload.ID, ttl)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// GetUpload returns an upload's details, with the offset taken from the size
+// of its data.
+func (s *RedisStore) GetUpload(ctx context.Context, id string) (upload Upload, err error) {
+	ctx, end := s.begin(ctx, "get_upload", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	fields := pipe.HGetAll(ctx, uploadPrefix+id)
+	size := pipe.StrLen(ctx, uploadDataPrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Upload{}, err
+	}
+	f := fields.Val()
+	if len(f) == 0 {
+		return Upload{}, ErrNotFound
+	}
+
+	upload = Upload{
+		ID:       id,
+		ClientIP: f["client_ip"],
+		Claimed:  f["claimed"] != "" || f["paste_id"] != "",
+		PasteID:  f["paste_id"],
+	}
+	upload.Length, _ = strconv.ParseInt(f["length"], 10, 64)
+	upload.Secure, _ = strconv.ParseBool(f["secure"])
+	upload.Burn, _ = strconv.ParseBool(f["burn"])
+	if ms, err := strconv.ParseInt(f["ttl"], 10, 64); err == nil {
+		upload.TTL = time.Duration(ms) * time.Millisecond
+	}
+	upload.Offset = size.Val()
+	if upload.PasteID != "" {
+		upload.Offset = upload.Length
+	}
+	return upload, nil
+}
+
+// AppendUpload appends a chunk, refreshing the upload's expiry.
+func (s *RedisStore) AppendUpload(ctx context.Context, id string, offset int64, data []byte, ttl time.Duration) (n int64, err error) {
+	ctx, end := s.begin(ctx, "append_upload", id)
+	defer func() { end(err) }()
+
+	// The details are checked separately so the script touches a single key,
+	// keeping it within one hash slot on Redis Cluster
+	ok, err := s.client.PExpire(ctx, uploadPrefix+id, ttl).Result()
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrNotFound
+	}
+
+	res, err := appendAt.Run(ctx, s.client, []string{uploadDataPrefix + id}, offset, data, ttl.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, err
+	}
+	if res[0] < 0 {
+		return res[1], ErrOffsetMismatch
+	}
+	return res[0], nil
+}
+
+// UploadData returns an upload's data.
+func (s *RedisStore) UploadData(ctx context.Context, id string) (data []byte, err error) {
+	ctx, end := s.begin(ctx, "upload_data", id)
+	defer func() { end(err) }()
+
+	data, err = s.client.Get(ctx, uploadDataPrefix+id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// ClaimUpload claims an upload in a script, so an expired upload's details
+// aren't recreated without an expiry.
+func (s *RedisStore) ClaimUpload(ctx context.Context, id string) (ok bool, err error) {
+	ctx, end := s.begin(ctx, "claim_upload", id)
+	defer func() { end(err) }()
+
+	n, err := claim.Run(ctx, s.client, []string{uploadPrefix + id}).Int64()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 {
+		return false, ErrNotFound
+	}
+	return n == 1, nil
+}
+
+// CompleteUpload records the created paste and deletes the upload's data.
+func (s *RedisStore) CompleteUpload(ctx context.Context, id, pasteID string) (err error) {
+	ctx, end := s.begin(ctx, "complete_upload", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	pipe.HSet(ctx, uploadPrefix+id, "paste_id", pasteID)
+	pipe.Del(ctx, uploadDataPrefix+id)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// DeleteUpload removes an upload's details and data.
+func (s *RedisStore) DeleteUpload(ctx context.Context, id string) (err error) {
+	ctx, end := s.begin(ctx, "delete_upload", id)
+	defer func() { end(err) }()
+
+	pipe := s.client.Pipeline()
+	deleted := pipe.Del(ctx, uploadPrefix+id)
+	pipe.Del(ctx, uploadDataPrefix+id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if deleted.Val() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}